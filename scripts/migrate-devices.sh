@@ -0,0 +1,54 @@
+#!/usr/bin/env bash
+
+# Copies devices of the table keyed by id (stored before tenants existed) into the table keyed by tenantId + id.
+# Devices that don't have a tenantId are given the tenant of the arguments, existing devices of the target are
+# replaced by the copies. See "Migrating the devices table" of README.md.
+#
+# usage: ./scripts/migrate-devices.sh <source table> <target table> <tenant id>
+
+set -euo pipefail
+
+if [ $# -ne 3 ] ; then
+	echo "usage: $0 <source table> <target table> <tenant id>"
+	exit 1
+fi
+
+SOURCE=$1
+TARGET=$2
+TENANT=$3
+
+# batch writes take at most 25 items
+BATCH_SIZE=25
+
+workdir=$(mktemp -d)
+trap 'rm -rf "$workdir"' EXIT
+
+echo "Reading devices of $SOURCE ..."
+aws dynamodb scan --table-name "$SOURCE" --page-size 100 --output json \
+	| jq -c --arg tenant "$TENANT" '.Items[] | .tenantId = (.tenantId // {"S": $tenant})' > "$workdir/items"
+
+total=$(wc -l < "$workdir/items")
+echo "Copying $total devices to $TARGET ..."
+
+split -l $BATCH_SIZE "$workdir/items" "$workdir/batch."
+for batch in "$workdir"/batch.*;
+	do
+		jq -s --arg table "$TARGET" '{($table): [.[] | {"PutRequest": {"Item": .}}]}' "$batch" > "$batch.json"
+
+		# throttled writes come back as unprocessed items, they are sent again after a pause
+		for attempt in 1 2 3 4 5;
+			do
+				unprocessed=$(aws dynamodb batch-write-item --request-items "file://$batch.json" --output json | jq -c '.UnprocessedItems')
+				if [ "$unprocessed" == "{}" ] ; then
+					break
+				fi
+				if [ $attempt -eq 5 ] ; then
+					echo "Devices of $batch couldn't be written: $unprocessed"
+					exit 1
+				fi
+				echo "$unprocessed" > "$batch.json"
+				sleep $attempt
+			done
+	done
+
+echo "Done, $total devices are copied."
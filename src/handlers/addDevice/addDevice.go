@@ -1,170 +1,167 @@
 package main
 
 import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"policy"
+	"localserver"
+	"metrics"
+	"retry"
+	"schema"
+	"tracing"
 	"types"
 	"fmt"
-	"os"
+	"context"
 	"encoding/json"
 	"errors"
 	
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
-type SuccessResponse struct{
-	Status	string	`json:"status"`
-	Device	types.Device	`json:"data"`
-}
+// reasons of rejected inputs, they are Reason dimension of ValidationFailures metric
+const REASON_EMPTY_BODY = "empty_body"
+const REASON_INVALID_JSON = "invalid_json"
+const REASON_SCHEMA_VIOLATION = "schema_violation"
+const REASON_INVALID_ATTRIBUTES = "invalid_attributes"
 
-type dynamoDBAPI struct{
-	DynamoDB dynamodbiface.DynamoDBAPI
-}
+var ErrDeviceExists = errors.New("device already exists")
 
-var databseStruct *types.DatabseStruct
-var dynamodbapi *dynamoDBAPI
+type SuccessResponse = types.DeviceResponse
 
-func init(){
-	databseStruct = new(types.DatabseStruct)
-	region := os.Getenv("AWS_REGION")
-	dynamodbapi = new(dynamoDBAPI) // crate a setter that  can be used for inserting
-	sess, err := session.NewSession(&aws.Config{Region: &region},)
-	databseStruct.SessionError = err
-	svc := dynamodb.New(sess)
-	dynamodbapi.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
-	
-	if err != nil {
-		fmt.Println("There is an error while creating database session: " + err.Error())
-	}
-	
-	// Get table name from OS's environment
-	fetchedTableName :=os.Getenv("DEVICES_TABLE_NAME")
-	if len(fetchedTableName)==0 {
-		databseStruct.TableName =  nil;
-		fmt.Println("It is not possible to fetch device tabel name")
-	}else{
-		databseStruct.TableName = aws.String(fetchedTableName)   
-	}
+// devices table of the handler, it's built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Models map[string]types.DeviceModel	// attribute definitions of device models
 }
 
-
-
 // main AWS lambda function starting point.
 // It gets some inputs from client as json, parse it and tries to insert it into dynamodb.
 // valid input json is like types.Device struct
-func AddDevice(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+func (ig *dynamoDBAPI) AddDevice(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	
-	// there is some internal server error 
-	if databseStruct.SessionError != nil || databseStruct.TableName == nil {
-		return events.APIGatewayProxyResponse{
-			Body:	createErrorResponseJson(500, "Internal Server's Error occured"),
-			StatusCode: 500,
-		}, nil
+	// only callers with devices:write scope get here (see newHandler), and they can only insert into their own tenant
+	principal := auth.FromContext(ctx)
+	
+	// caller's roles must allow creating devices
+	if denied := policy.Check(principal, policy.PERMISSION_DEVICES_CREATE); denied != nil {
+		return *denied, nil
 	}
 	
 	// validate inputs of client's request (APIGatewayProxyRequest).
-	newDevice, err := validateInputs(request)
+	_, span := tracing.Start(ctx, "validate request")
+	newDevice, reason, err := validateInputs(request, ig.Models)
+	span.SetAttribute("validation.reason", reason)
+	span.Finish()
 	
 	// if inputs are not suitable, return HTTP 400 error
 	if err != nil {
+		metrics.ValidationFailure(ctx, reason)
 		return events.APIGatewayProxyResponse{
 			Body:	"" + err.Error(),
 			StatusCode: 400,
 		}, nil
 	}
 	
-	_, err = dynamodbapi.insertItemToDatabase(newDevice)
+	// tenant always comes from the caller, a body that names another tenant is rejected and logged
+	if requestedTenantId := getRequestedTenantId(request); len(requestedTenantId) != 0 && requestedTenantId != principal.TenantID {
+		fmt.Printf("Cross-tenant access attempt: principal %s of tenant %s tried to insert device %s into tenant %s\n", principal.ID, principal.TenantID, newDevice.ID, requestedTenantId)
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(403, "Devices can only be inserted into caller's own tenant"),
+			StatusCode: 403,
+		}, nil
+	}
 	
-	// If an internal error occured in the database  , return HTTP error 500
-	if err != nil {
+	_, err = ig.insertItemToDatabase(ctx, principal.TenantID, newDevice)
+	
+	// an existing device is never replaced, its status, tags, shadow and history are changed by their own endpoints
+	if err == ErrDeviceExists {
 		return events.APIGatewayProxyResponse{
-			Body:	createErrorResponseJson(500,"Internal Server's Error occured"),
-			StatusCode: 500,
+			Body:	createErrorResponseJson(409, "Device " + newDevice.ID + " already exists, devices can't be inserted again"),
+			StatusCode: 409,
 		}, nil
 	}
 	
+	// If an internal error occured in the database, apigw.ErrorMapping returns HTTP error 500
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	
 	// looks fine, item inserted and result will be returned.
+	_, span = tracing.Start(ctx, "serialize response")
+	defer span.Finish()
 	return createSuccessResponseJson(newDevice)
 }
 
-func validateInputs(request events.APIGatewayProxyRequest) (types.Device, error) {
-	
-	var errorFlag bool = false
-	
-	// Initialize device json object(struct)
-	device := types.Device{
-		ID:				"",
-		DeviceModel:	"",
-		Name:			"",
-		Note:			"",
-		Serial:			"",
-	}
-	
-	errorMessage := ""
+// validateInputs returns the requested device, or reason and error body of rejecting it.
+// body is validated against the device schema (GET /schemas/device.json) and its attributes against definitions
+// of its model, every violation is reported.
+func validateInputs(request events.APIGatewayProxyRequest, models map[string]types.DeviceModel) (types.Device, string, error) {
 	
 	if len(request.Body) == 0 {
-		errorMessage = "No inputs provided, please provide inputs in json format."
-		return types.Device{}, errors.New(createErrorResponseJson(400, errorMessage))
+		errorMessage := "No inputs provided, please provide inputs in json format."
+		return types.Device{}, REASON_EMPTY_BODY, errors.New(createErrorResponseJson(400, errorMessage))
 	}
 	
-	// Parse request body, gets body of request then parse it to json and finally assigns it to device 
-	var err = json.Unmarshal([]byte(request.Body), &device)
-
-	if err != nil {
-		errorMessage = "Wrong format: Inputs must be a valid json."
-		return types.Device{}, errors.New(createErrorResponseJson(400, errorMessage))
+	// Parse request body as plain json first, so the schema sees exactly what client sent
+	var body interface{}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		errorMessage := "Wrong format: Inputs must be a valid json."
+		return types.Device{}, REASON_INVALID_JSON, errors.New(createErrorResponseJson(400, errorMessage))
 	}
 	
-	errorMessage = "Following fields are not provided: "
+	// tenantId isn't a field of a device, AddDevice checks it against the caller
+	object, _ := body.(map[string]interface{})
+	delete(object, "tenantId")
 	
-	if len(device.ID) == 0 {
-		errorMessage += "id, "
-		errorFlag = true
+	// statuses are only changed by transitions, so new devices start as provisioned
+	violations := api.ValidateDevice(body, false)
+	if status, ok := object["status"]; ok && status != types.STATUS_PROVISIONED {
+		violations = append(violations, schema.Violation{Pointer: "/status", Message: "must be " + types.STATUS_PROVISIONED + ", statuses are changed by POST /devices/{id}:transition"})
 	}
-	
-	if len(device.DeviceModel) == 0 {
-		errorMessage += "deviceModel, "
-		errorFlag = true
+	if len(violations) != 0 {
+		errorMessage := "Device doesn't match its schema " + api.SCHEMAS_PATH + "device.json"
+		return types.Device{}, REASON_SCHEMA_VIOLATION, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
 	}
 	
-	if len(device.Name) == 0 {
-		errorMessage += "name, "
-		errorFlag = true
-	}
+	device := types.Device{}
+	json.Unmarshal([]byte(request.Body), &device)
+	device.Status = types.STATUS_PROVISIONED
 	
-	if len(device.Note) == 0 {
-		errorMessage += "note, "
-		errorFlag = true
+	if violations := api.ValidateAttributes(models, device.DeviceModel, device.Attributes); len(violations) != 0 {
+		errorMessage := "Attributes don't match definitions of device model " + device.DeviceModel
+		return types.Device{}, REASON_INVALID_ATTRIBUTES, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
 	}
 	
-	if len(device.Serial) == 0 {
-		errorMessage += "serial, "
-		errorFlag = true
-	}
-	
-	// if some fields are missin, report it as an error
-	if errorFlag == true {
-		return types.Device{}, errors.New(createErrorResponseJson(400, errorMessage))
-	}
 	// everything looks fine, return created device
-	return device, nil
+	return device, "", nil
+}
+
+// returns tenantId field of request's body, if there is any
+func getRequestedTenantId(request events.APIGatewayProxyRequest) string {
+	body := struct {
+		TenantID	string	`json:"tenantId"`
+	}{}
+	json.Unmarshal([]byte(request.Body), &body)
+	return body.TenantID
 }
 
 func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
-	
-	errorResponse := types.ErrorResponse { ErrorMessage: types.ErrorMessage { Code: errorCode, Message: errorMessage,},}
-	errorResponseJson, _ := json.MarshalIndent(&errorResponse, "", "\t")
-	return string(errorResponseJson)
+	return types.NewErrorResponseJson(errorCode, errorMessage)
 }
 
 func createSuccessResponseJson(newDevice types.Device) (events.APIGatewayProxyResponse, error){
 	successResponse := SuccessResponse {
-		"requested item inserted",
-		newDevice,
+		Status: "requested item inserted",
+		Device: newDevice,
 	}
 	
 	successResponseJson, _ := json.MarshalIndent(&successResponse, "", "\t")
@@ -175,24 +172,46 @@ func createSuccessResponseJson(newDevice types.Device) (events.APIGatewayProxyRe
 	}, nil 
 }
 
-// function that just insert requested item to dynamodb's table.
-func (ig *dynamoDBAPI) insertItemToDatabase(newDevice types.Device)(*dynamodb.PutItemOutput, error){
+// function that just insert requested item to dynamodb's table, ErrDeviceExists when the tenant has a device
+// with the same id. tenantId is the partition key of the item, so the device is only visible to its tenant.
+func (ig *dynamoDBAPI) insertItemToDatabase(ctx context.Context, tenantId string, newDevice types.Device)(*dynamodb.PutItemOutput, error){
 	
-	// marshal newDevice struct(object) as a dynamodb item 
+	// marshal newDevice struct(object) as a dynamodb item, attributes are stored as a native map (M)
 	item, _ := dynamodbattribute.MarshalMap(newDevice)
+	item["tenantId"] = &dynamodb.AttributeValue{S: aws.String(tenantId)}
 	
 	// preparing an input for dynamodb
 	input := &dynamodb.PutItemInput{
 		Item: item,
-		TableName: databseStruct.TableName,
+		TableName: ig.TableName,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
 	}
 	
-	// put created input to dynamodb
-	output, err := ig.DynamoDB.PutItem(input)
+	// put created input to dynamodb, throttled calls are retried
+	var output *dynamodb.PutItemOutput
+	err := ig.Retry.Do(ctx, func() (err error) {
+		output, err = ig.DynamoDB.PutItemWithContext(ctx, input)
+		return err
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil, ErrDeviceExists
+	}
 	return output, err
 }
 
+// newHandler wraps AddDevice with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services.
+func newHandler(services *apigw.Services) apigw.Handler {
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Models: services.Config.DeviceModels}
+	return apigw.Chain(table.AddDevice, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+}
+
 func main(){
-	// aws lambda function calls it
-	lambda.Start(AddDevice)
+	// aws lambda function (or local server) calls it
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("addDevice")...)
 }
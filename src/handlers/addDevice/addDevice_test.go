@@ -1,20 +1,38 @@
 package main
 
 import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"config"
+	"schema"
 	"types"
+	"fmt"
+	"errors"
+	"strings"
 	"testing"
+	"context"
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
 )
 
-
 // A fakeDynamoDB instance for mocking test that emulates real DynamoDB
 type FakeDynamoDBAPI struct {
 	dynamodbiface.DynamoDBAPI
 }
 
+// message of bodies that violate the device schema
+const SCHEMA_MESSAGE = "Device doesn't match its schema /schemas/device.json"
+
+// a viewer key that only has devices:read scope, keys of apigwtest have devices:write too
+const READ_API_KEY = "readkey." + apigwtest.SECRET
+
+var testKeys = &apigwtest.FakeKeysDynamoDBAPI{Keys: []auth.ApiKey{{ID: "readkey", Scopes: []string{auth.SCOPE_DEVICES_READ}, Roles: []string{"viewer"}, TenantID: apigwtest.TENANT}}}
 
 // TestCase struct that contains all reuested and expected values for unit testing
 type TestCase struct {
@@ -25,14 +43,27 @@ type TestCase struct {
 	ExpectedStatusCode 	int
 }
 
-
 // a mocked version of DynamoDB's PutItem function.
 // in testing state, instead of calling real DynamoDB's PutItem, we try to emulate it.
 // insertItemToDatabase function of addDevice.go calls this function in Testing state.  
-func (d *FakeDynamoDBAPI) PutItem(input *dynamodb.PutItemInput) (*dynamodb.PutItemOutput, error) {
+func (d *FakeDynamoDBAPI) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if *input.Item["id"].S == "id_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	if *input.Item["id"].S == "id_exists" {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
 	return new(dynamodb.PutItemOutput), nil
 }
 
+// A fake devices table that can't be reached, like the database of baseline tests
+type UnreachableDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+func (d *UnreachableDynamoDBAPI) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	return nil, errors.New("Unexpected Error has occured")
+}
 
 func TestAddDevice(t *testing.T) {
 
@@ -52,20 +83,20 @@ func TestAddDevice(t *testing.T) {
 		{
 			Name:				"** Testing json with missing field {id} **",
 			Request: 			events.APIGatewayProxyRequest{Body: "{\"id\":\"\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"testName\" , \"note\":\"testNote\" , \"serial\":\"testSerial\" }"},
-			ExpectedBody: 		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Following fields are not provided: id, \"\n\t}\n}",
+			ExpectedBody: 		types.NewViolationsResponseJson(400, SCHEMA_MESSAGE, []schema.Violation{{Pointer: "/id", Message: "must not be empty"}}),
 			ExpectedStatusCode:	400,
 		},
 		{
 			Name:				"** Testing json with missing field {deviceModel, note} **",
 			Request:			events.APIGatewayProxyRequest{Body: "{\"id\":\"1\" , \"deviceModel\":\"\" , \"name\":\"testName\" , \"note\":\"\" , \"serial\":\"testSerial\" }"},
-			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Following fields are not provided: deviceModel, note, \"\n\t}\n}",
+			ExpectedBody:		types.NewViolationsResponseJson(400, SCHEMA_MESSAGE, []schema.Violation{{Pointer: "/deviceModel", Message: "must not be empty"}, {Pointer: "/note", Message: "must not be empty"}}),
 			ExpectedStatusCode:	400,
 		},
 	
 		{
 			Name: 				"** Testing json with missing field {serial, name, deviceModel} **",
 			Request: 			events.APIGatewayProxyRequest{Body: "{\"id\":\"1\" , \"deviceModel\":\"\" , \"name\":\"\" , \"note\":\"testNote\" , \"serial\":\"\" }"},
-			ExpectedBody: 		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Following fields are not provided: deviceModel, name, serial, \"\n\t}\n}",
+			ExpectedBody: 		types.NewViolationsResponseJson(400, SCHEMA_MESSAGE, []schema.Violation{{Pointer: "/deviceModel", Message: "must not be empty"}, {Pointer: "/name", Message: "must not be empty"}, {Pointer: "/serial", Message: "must not be empty"}}),
 			ExpectedStatusCode:	400,
 		},
 
@@ -79,21 +110,144 @@ func TestAddDevice(t *testing.T) {
 
 	}
 
-    
+	runTestCases(t, newHandler(apigwtest.NewServices(&UnreachableDynamoDBAPI{}, testKeys)), testCases)
+
+} // end of TestAddDevice function
+
+func TestAddDeviceAuthorization(t *testing.T) {
+
+	testCases := []TestCase{
+		{
+			Name: 				"** Testing missing api key **",
+			Request: 			events.APIGatewayProxyRequest{Headers: map[string]string{}, Body: ""},
+			ExpectedBody: 		"{\n\t\"error\": {\n\t\t\"code\": 401,\n\t\t\"message\": \"No API key provided\"\n\t}\n}",
+			ExpectedStatusCode:	401,
+		},
+		{
+			Name: 				"** Testing invalid api key **",
+			Request: 			events.APIGatewayProxyRequest{Headers: map[string]string{"x-api-key": "writekey.wrong_secret"}, Body: ""},
+			ExpectedBody: 		"{\n\t\"error\": {\n\t\t\"code\": 401,\n\t\t\"message\": \"Invalid API key\"\n\t}\n}",
+			ExpectedStatusCode:	401,
+		},
+		{
+			Name: 				"** Testing api key without devices:write scope **",
+			Request: 			events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": READ_API_KEY}, Body: ""},
+			ExpectedBody: 		"{\n\t\"error\": {\n\t\t\"code\": 403,\n\t\t\"message\": \"API key lacks required scope: devices:write\"\n\t}\n}",
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name: 				"** Testing operator creating a device **",
+			Request: 			events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}, Body: ""},
+			ExpectedBody: 		"{\n\t\"error\": {\n\t\t\"code\": 403,\n\t\t\"message\": \"Operation is not permitted: none of roles [operator] grants devices:create\"\n\t}\n}",
+			ExpectedStatusCode:	403,
+		},
+	}
+
+	runTestCases(t, newHandler(apigwtest.NewServices(&FakeDynamoDBAPI{}, testKeys)), testCases)
+
+} // end of TestAddDeviceAuthorization function
+
+func TestAddDeviceSchema(t *testing.T) {
+
+	testCases := []TestCase{
+		{
+			Name: 				"** Testing json with all kinds of violations **",
+			Request: 			events.APIGatewayProxyRequest{Body: "{\"id\":1 , \"name\":\"\" , \"note\":\"testNote\" , \"serial\":\"" + strings.Repeat("s", 129) + "\", \"color\":\"red\" }"},
+			ExpectedBody: 		types.NewViolationsResponseJson(400, SCHEMA_MESSAGE, []schema.Violation{
+				{Pointer: "/deviceModel", Message: "is required"},
+				{Pointer: "/color", Message: "is not allowed"},
+				{Pointer: "/id", Message: "must be string"},
+				{Pointer: "/name", Message: "must not be empty"},
+				{Pointer: "/serial", Message: "must be at most 128 characters long"},
+			}),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing json with a status other than provisioned **",
+			Request:			events.APIGatewayProxyRequest{Body: "{\"id\":\"1\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"testName\" , \"note\":\"testNote\" , \"serial\":\"testSerial\", \"status\":\"active\"}"},
+			ExpectedBody:		types.NewViolationsResponseJson(400, SCHEMA_MESSAGE, []schema.Violation{
+				{Pointer: "/status", Message: "must be provisioned, statuses are changed by POST /devices/{id}:transition"},
+			}),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing json with another tenant **",
+			Request:			events.APIGatewayProxyRequest{Body: "{\"id\":\"1\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"testName\" , \"note\":\"testNote\" , \"serial\":\"testSerial\", \"tenantId\":\"other_tenant\"}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 403,\n\t\t\"message\": \"Devices can only be inserted into caller's own tenant\"\n\t}\n}",
+			ExpectedStatusCode:	403,
+		},
+	}
+
+	runTestCases(t, newHandler(apigwtest.NewServices(&FakeDynamoDBAPI{}, testKeys)), testCases)
+
+} // end of TestAddDeviceSchema function
+
+func TestAddDeviceToDatabase(t *testing.T) {
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing valid json with all fields **",
+			Request:			events.APIGatewayProxyRequest{Body: "{\"id\":\"1\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"testName\" , \"note\":\"testNote\" , \"serial\":\"testSerial\"}"},
+			ExpectedBody:		"{\n\t\"status\": \"requested item inserted\",\n\t\"data\": {\n\t\t\"id\": \"1\",\n\t\t\"deviceModel\": \"testDeviceModel\",\n\t\t\"name\": \"testName\",\n\t\t\"note\": \"testNote\",\n\t\t\"serial\": \"testSerial\",\n\t\t\"status\": \"provisioned\"\n\t}\n}",
+			ExpectedStatusCode:	201,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			Request:			events.APIGatewayProxyRequest{Body: "{\"id\":\"id_error\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"testName\" , \"note\":\"testNote\" , \"serial\":\"testSerial\"}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}",
+			ExpectedStatusCode:	500,
+		},
+		{
+			Name:				"** Testing device that exists **",
+			Request:			events.APIGatewayProxyRequest{Body: "{\"id\":\"id_exists\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"testName\" , \"note\":\"testNote\" , \"serial\":\"testSerial\"}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 409,\n\t\t\"message\": \"Device id_exists already exists, devices can't be inserted again\"\n\t}\n}",
+			ExpectedStatusCode:	409,
+		},
+	}
+
+	runTestCases(t, newHandler(apigwtest.NewServices(&FakeDynamoDBAPI{}, testKeys)), testCases)
+
+} // end of TestAddDeviceToDatabase function
+
+// runTestCases sends requests of testCases to handler and checks their responses
+func runTestCases(t *testing.T, handler apigw.Handler, testCases []TestCase) {
+
 	for _, test := range testCases {
 
+		// requests without explicit headers are sent with a valid write key
+		if test.Request.Headers == nil {
+			test.Request.Headers = map[string]string{"X-Api-Key": apigwtest.ADMIN_API_KEY}
+		}
+
 		// calls addDevice.go's AddDevice function.
-		databseStruct = new(types.DatabseStruct)
-		databseStruct.TableName = aws.String("test_table_name");
-		response, _ := AddDevice(test.Request)
+		response, _ := handler(context.Background(), test.Request)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("POST", "/devices", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
 
 		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
 			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
 		}
 
 	}
+}
 
-} // end of TestAddDevice function
+func TestAddDeviceWithInvalidConfig(t *testing.T) {
+
+	// as DEVICES_TABLE_NAME is not set, handler must not touch the database
+	services := apigwtest.NewServices(&FakeDynamoDBAPI{}, testKeys)
+	services.ConfigError = &config.Error{Problems: []string{"DEVICES_TABLE_NAME is not set"}}
+
+	request := events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.ADMIN_API_KEY}, Body: "{\"id\":\"1\" , \"deviceModel\":\"testDeviceModel\" , \"name\":\"testName\" , \"note\":\"testNote\" , \"serial\":\"testSerial\"}"}
+	response, _ := newHandler(services)(context.Background(), request)
+
+	expectedBody := "{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}"
+	if response.StatusCode != 500 || response.Body != expectedBody {
+		t.Errorf("** Testing invalid configuration ** \n \t<expected error-code: 500> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", response.StatusCode, expectedBody, response.Body)
+	}
+} // end of TestAddDeviceWithInvalidConfig function
 
 func TestCreateSuccessResponseJson(t *testing.T){
 
@@ -114,7 +268,6 @@ func TestCreateSuccessResponseJson(t *testing.T){
 		},
 	}
 
-
     
 	for _, test := range testCases {
 
@@ -126,3 +279,61 @@ func TestCreateSuccessResponseJson(t *testing.T){
 	}
 
 } // end of TestCreateSuccessResponseJson fucntion
+
+// A fake devices table that keeps the last inserted item
+type RecordingDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	Item	map[string]*dynamodb.AttributeValue
+}
+
+func (d *RecordingDynamoDBAPI) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	d.Item = input.Item
+	return new(dynamodb.PutItemOutput), nil
+}
+
+func TestAddDeviceAttributes(t *testing.T) {
+
+	devices := &RecordingDynamoDBAPI{}
+	services := apigwtest.NewServices(devices, testKeys)
+	services.Config.DeviceModels = map[string]types.DeviceModel{
+		"sensorModel": {
+			Attributes:	map[string]schema.Schema{
+				"samplingRate":	{"type": "number", "minimum": 0.1},
+				"network":		{"type": "object", "properties": schema.Schema{"dhcp": schema.Schema{"type": "boolean"}}},
+			},
+			Required:	[]string{"samplingRate"},
+		},
+	}
+	handler := newHandler(services)
+
+	device := "{\"id\":\"1\", \"deviceModel\":\"sensorModel\", \"name\":\"testName\", \"note\":\"testNote\", \"serial\":\"testSerial\", \"attributes\": %s}"
+
+	// attributes are stored as a native map and returned as they were sent
+	request := events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.ADMIN_API_KEY}, Body: fmt.Sprintf(device, "{\"samplingRate\": 2.5, \"network\": {\"dhcp\": true}}")}
+	response, _ := handler(context.Background(), request)
+
+	expectedPart := "\t\t\"attributes\": {\n\t\t\t\"network\": {\n\t\t\t\t\"dhcp\": true\n\t\t\t},\n\t\t\t\"samplingRate\": 2.5\n\t\t}"
+	if response.StatusCode != 201 || !strings.Contains(response.Body, expectedPart) {
+		t.Errorf("** Testing valid attributes ** \n \t<expected error-code: 201> <resulted error-code: %d> \n \t<expected body part: %s> <resulted body: %s>", response.StatusCode, expectedPart, response.Body)
+	}
+	stored := devices.Item["attributes"]
+	if stored == nil || stored.M == nil || *stored.M["samplingRate"].N != "2.5" || !*stored.M["network"].M["dhcp"].BOOL {
+		t.Errorf("** Testing stored attributes ** \n \t<resulted item: %v>", devices.Item)
+	}
+	for _, problem := range api.CheckResponse("POST", "/devices", response) {
+		t.Errorf("** Testing valid attributes ** \n \t<response drifted from the document: %s>", problem)
+	}
+
+	// every violation of model's definitions is reported
+	devices.Item = nil
+	request.Body = fmt.Sprintf(device, "{\"samplingRate\": 0, \"network\": {\"dhcp\": \"yes\"}}")
+	response, _ = handler(context.Background(), request)
+
+	expectedBody := types.NewViolationsResponseJson(400, "Attributes don't match definitions of device model sensorModel", []schema.Violation{
+		{Pointer: "/attributes/network/dhcp", Message: "must be boolean"},
+		{Pointer: "/attributes/samplingRate", Message: "must be at least 0.1"},
+	})
+	if response.StatusCode != 400 || response.Body != expectedBody || devices.Item != nil {
+		t.Errorf("** Testing invalid attributes ** \n \t<expected error-code: 400> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", response.StatusCode, expectedBody, response.Body)
+	}
+} // end of TestAddDeviceAttributes function
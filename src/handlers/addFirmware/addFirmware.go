@@ -0,0 +1,133 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"firmware"
+	"policy"
+	"localserver"
+	"metrics"
+	"types"
+	"fmt"
+	"time"
+	"context"
+	"strings"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// reasons of rejected inputs, they are Reason dimension of ValidationFailures metric
+const REASON_EMPTY_BODY = "empty_body"
+const REASON_INVALID_JSON = "invalid_json"
+const REASON_SCHEMA_VIOLATION = "schema_violation"
+
+type SuccessResponse = types.FirmwareResponse
+
+// firmware store of the handler, it's built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	Now func() time.Time
+	Firmware *firmware.Store
+}
+
+// main AWS lambda function starting point.
+// It adds a firmware version of a device model to the catalog of caller's tenant. Versions can't be changed or added
+// again, so a checksum that devices verified stays the checksum of its version. Campaigns roll out versions of the catalog.
+func (ig *dynamoDBAPI) AddFirmware(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:write scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+	if denied := policy.Check(principal, policy.PERMISSION_FIRMWARE_PUBLISH); denied != nil {
+		return *denied, nil
+	}
+
+	model := request.PathParameters["model"]
+	if model == "" {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "No Model Field Provided"),
+			StatusCode: 404,
+		}, nil
+	}
+
+	firmwareRequest, reason, err := validateFirmware(request)
+	if err != nil {
+		metrics.ValidationFailure(ctx, reason)
+		return events.APIGatewayProxyResponse{
+			Body:	err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	added := types.Firmware{
+		DeviceModel:	model,
+		Version:		firmwareRequest.Version,
+		Checksum:		firmwareRequest.Checksum,
+		ReleaseNotes:	firmwareRequest.ReleaseNotes,
+		CreatedAt:		ig.Now().UTC().Format(time.RFC3339),
+	}
+	err = ig.Firmware.PutFirmware(ctx, principal.TenantID, added)
+	if err == firmware.ErrFirmwareExists {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, "Firmware " + added.Version + " of " + model + " already exists, versions can't be changed"),
+			StatusCode: 409,
+		}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	successResponseJson, _ := json.MarshalIndent(&SuccessResponse{Status: "firmware added", Firmware: added}, "", "\t")
+	return events.APIGatewayProxyResponse{
+		Body:	string(successResponseJson),
+		StatusCode: 201,
+	}, nil
+}
+
+// validateFirmware returns the firmware of the body, or reason and error body of rejecting it.
+// The body is validated against the firmware schema (GET /schemas/firmware.json).
+func validateFirmware(request events.APIGatewayProxyRequest) (types.FirmwareRequest, string, error) {
+	firmwareRequest := types.FirmwareRequest{}
+	if len(strings.TrimSpace(request.Body)) == 0 {
+		return firmwareRequest, REASON_EMPTY_BODY, errors.New(createErrorResponseJson(400, "No inputs provided, please provide inputs in json format."))
+	}
+
+	var body interface{}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return firmwareRequest, REASON_INVALID_JSON, errors.New(createErrorResponseJson(400, "Wrong format: Inputs must be a valid json."))
+	}
+
+	if violations := api.ValidateFirmware(body); len(violations) != 0 {
+		errorMessage := "Firmware doesn't match its schema " + api.SCHEMAS_PATH + "firmware.json"
+		return firmwareRequest, REASON_SCHEMA_VIOLATION, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
+	}
+	json.Unmarshal([]byte(request.Body), &firmwareRequest)
+	return firmwareRequest, "", nil
+}
+
+func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
+	return types.NewErrorResponseJson(errorCode, errorMessage)
+}
+
+// newHandler wraps AddFirmware with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services. The firmware table is only needed by firmware handlers, so it's checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	if len(services.Config.FirmwareTableName) == 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: []string{"FIRMWARE_TABLE_NAME is not set"}}
+	}
+	store := &firmware.Store{DynamoDB: services.DynamoDB, FirmwareTable: aws.String(services.Config.FirmwareTableName), Retry: services.Retry}
+	catalog := &dynamoDBAPI{Now: time.Now, Firmware: store}
+	return apigw.Chain(catalog.AddFirmware, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("addFirmware")...)
+}
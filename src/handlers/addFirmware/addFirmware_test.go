@@ -0,0 +1,123 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"firmware"
+	"retry"
+	"types"
+	"testing"
+	"context"
+	"time"
+	"errors"
+	"strings"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+}
+
+// A fakeDynamoDB instance for mocking test that emulates the firmware table with version "2.0.0" of "thermo-2" of
+// "tenant_test". Its PutItem checks the condition of firmware.Store.PutFirmware, version "error" fails.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	items	map[string]bool
+}
+
+// a mocked version of DynamoDB's PutItem function
+func (fd *FakeDynamoDBAPI) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	key := *input.Item["model"].S + "/" + *input.Item["version"].S
+	if *input.Item["version"].S == "error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	if fd.items[key] {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	fd.items[key] = true
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestAddFirmware(t *testing.T) {
+
+	add := func(key string, body string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: map[string]string{"X-Api-Key": key}, PathParameters: map[string]string{"model": "thermo-2"}, Body: body}
+	}
+	errorBody := func(code int, message string) string {
+		return types.NewErrorResponseJson(code, message)
+	}
+	checksum := "sha256:" + strings.Repeat("ab", 32)
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing adding a firmware version **",
+			InputRequest:		add(apigwtest.ADMIN_API_KEY, "{\"version\": \"2.1.0\", \"checksum\": \"" + checksum + "\", \"releaseNotes\": \"Fixes drift of the sensor\"}"),
+			ExpectedBody:		"{\n\t\"status\": \"firmware added\",\n\t\"data\": {\n\t\t\"deviceModel\": \"thermo-2\",\n\t\t\"version\": \"2.1.0\",\n\t\t\"checksum\": \"" + checksum + "\",\n\t\t\"releaseNotes\": \"Fixes drift of the sensor\",\n\t\t\"createdAt\": \"2018-06-26T08:00:00Z\"\n\t}\n}",
+			ExpectedStatusCode:	201,
+		},
+		{
+			Name:				"** Testing version that exists **",
+			InputRequest:		add(apigwtest.ADMIN_API_KEY, "{\"version\": \"2.0.0\", \"checksum\": \"" + checksum + "\"}"),
+			ExpectedBody:		errorBody(409, "Firmware 2.0.0 of thermo-2 already exists, versions can't be changed"),
+			ExpectedStatusCode:	409,
+		},
+		{
+			Name:				"** Testing invalid checksum **",
+			InputRequest:		add(apigwtest.ADMIN_API_KEY, "{\"version\": \"2.1.0\", \"checksum\": \"md5:abc\"}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Firmware doesn't match its schema /schemas/firmware.json\",\n\t\t\"violations\": [\n\t\t\t{\n\t\t\t\t\"pointer\": \"/checksum\",\n\t\t\t\t\"message\": \"must match pattern ^sha256:[0-9a-f]{64}$\"\n\t\t\t}\n\t\t]\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing invalid json **",
+			InputRequest:		add(apigwtest.ADMIN_API_KEY, "{\"version\": "),
+			ExpectedBody:		errorBody(400, "Wrong format: Inputs must be a valid json."),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		add(apigwtest.ADMIN_API_KEY, "{\"version\": \"error\", \"checksum\": \"" + checksum + "\"}"),
+			ExpectedBody:		errorBody(500, "Internal Server's Error occured"),
+			ExpectedStatusCode:	500,
+		},
+		{
+			Name:				"** Testing operator adding a firmware version **",
+			InputRequest:		add(apigwtest.OPERATOR_API_KEY, "{\"version\": \"2.1.0\", \"checksum\": \"" + checksum + "\"}"),
+			ExpectedBody:		errorBody(403, "Operation is not permitted: none of roles [operator] grants firmware:publish"),
+			ExpectedStatusCode:	403,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked database, versions are added at a fixed time
+		now := time.Unix(1530000000, 0)
+		fake := &FakeDynamoDBAPI{items: map[string]bool{firmware.ModelKey("tenant_test", "thermo-2") + "/2.0.0": true}}
+		store := &firmware.Store{DynamoDB: fake, FirmwareTable: aws.String("test_firmware_table_name"), Retry: retry.Default}
+		catalog := &dynamoDBAPI{Now: func() time.Time { return now }, Firmware: store}
+		handler := apigw.Chain(catalog.AddFirmware, apigw.Standard(apigwtest.NewServices(fake, nil), auth.SCOPE_DEVICES_WRITE)...)
+
+		// calls addFirmware.go's AddFirmware function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("POST", "/firmware/{model}", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+
+} // end of TestAddFirmware function
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"policy"
+	"localserver"
+	"types"
+	"fmt"
+	"time"
+	"errors"
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type MintRequest = types.MintKeyRequest
+type MintedKey = types.MintedKey
+type SuccessResponse = types.MintedKeyResponse
+type RevokeResponse = types.StatusResponse
+
+// api keys table of the handler, it's built by newHandler from apigw.Services
+type keysAPI struct{
+	Keys	*auth.KeyStore
+}
+
+
+// main AWS lambda function starting point.
+// POST /apikeys mints a new key and DELETE /apikeys/{id} revokes an existing one.
+// caller must have an api key with keys:admin scope.
+func (ka *keysAPI) ApiKeys(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	switch request.HTTPMethod {
+	case "POST":
+		return ka.mintKey(ctx, request)
+	case "DELETE":
+		return ka.revokeKey(ctx, request)
+	}
+
+	return events.APIGatewayProxyResponse{
+		Body:	createErrorResponseJson(405, "Method not allowed"),
+		StatusCode: 405,
+	}, nil
+}
+
+func (ka *keysAPI) mintKey(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	mintRequest, err := validateInputs(request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(400, err.Error()),
+			StatusCode: 400,
+		}, nil
+	}
+
+	plainKey, apiKey, err := auth.GenerateKey(mintRequest.Name, mintRequest.TenantID, mintRequest.Scopes, mintRequest.Roles, time.Now().UTC().Format(time.RFC3339))
+	apiKey.RateLimit = mintRequest.RateLimit
+	if err == nil {
+		err = ka.Keys.PutKey(ctx, apiKey)
+	}
+	// store errors are logged and mapped to 500 (or 503 on timeout) by apigw.ErrorMapping
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	successResponse := SuccessResponse{
+		Status: "api key created",
+		Key: MintedKey{
+			ID:			apiKey.ID,
+			Name:		apiKey.Name,
+			TenantID:	apiKey.TenantID,
+			Scopes:		apiKey.Scopes,
+			Roles:		apiKey.Roles,
+			RateLimit:	apiKey.RateLimit,
+			CreatedAt:	apiKey.CreatedAt,
+			Key:		plainKey,
+		},
+	}
+	successResponseJson, _ := json.MarshalIndent(&successResponse, "", "\t")
+
+	return events.APIGatewayProxyResponse{
+		Body:	string(successResponseJson),
+		StatusCode: 201,
+	}, nil
+}
+
+func (ka *keysAPI) revokeKey(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	id := request.PathParameters["id"]
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "No ID Field Provided"),
+			StatusCode: 404,
+		}, nil
+	}
+
+	err := ka.Keys.RevokeKey(ctx, id)
+	if err == auth.ErrKeyNotFound {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Desired api key with provided id was not founded"),
+			StatusCode: 404,
+		}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	revokeResponseJson, _ := json.MarshalIndent(&RevokeResponse{Status: "api key revoked"}, "", "\t")
+	return events.APIGatewayProxyResponse{
+		Body:	string(revokeResponseJson),
+		StatusCode: 200,
+	}, nil
+}
+
+func validateInputs(request events.APIGatewayProxyRequest) (MintRequest, error) {
+	mintRequest := MintRequest{}
+
+	if len(request.Body) == 0 {
+		return mintRequest, errors.New("No inputs provided, please provide inputs in json format.")
+	}
+
+	if err := json.Unmarshal([]byte(request.Body), &mintRequest); err != nil {
+		return mintRequest, errors.New("Wrong format: Inputs must be a valid json.")
+	}
+
+	if len(mintRequest.Name) == 0 || len(mintRequest.Scopes) == 0 {
+		return mintRequest, errors.New("Following fields are required: name, scopes")
+	}
+
+	for _, scope := range mintRequest.Scopes {
+		if !auth.IsKnownScope(scope) {
+			return mintRequest, fmt.Errorf("Unknown scope: %s", scope)
+		}
+		// keys that touch devices are always bound to a tenant and have roles
+		if (scope == auth.SCOPE_DEVICES_READ || scope == auth.SCOPE_DEVICES_WRITE) && (len(mintRequest.TenantID) == 0 || len(mintRequest.Roles) == 0) {
+			return mintRequest, fmt.Errorf("Following fields are required for scope %s: tenantId, roles", scope)
+		}
+	}
+
+	// a key without rateLimit uses the default limit
+	if mintRequest.RateLimit != nil && (mintRequest.RateLimit.Burst < 1 || mintRequest.RateLimit.PerSecond <= 0) {
+		return mintRequest, errors.New("rateLimit must have a positive burst and perSecond")
+	}
+
+	for _, role := range mintRequest.Roles {
+		if !policy.IsKnownRole(role) {
+			return mintRequest, fmt.Errorf("Unknown role: %s", role)
+		}
+	}
+
+	return mintRequest, nil
+}
+
+func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
+	return types.NewErrorResponseJson(errorCode, errorMessage)
+}
+
+// newHandler wraps ApiKeys with the shared middlewares, api keys are not bound to a tenant.
+// all of its dependencies come from services.
+func newHandler(services *apigw.Services) apigw.Handler {
+	keys := &keysAPI{Keys: services.Keys}
+	return apigw.Chain(keys.ApiKeys,
+		apigw.Logging(),
+		apigw.Trace(services.Tracer),
+		apigw.Measure(services.Metrics),
+		apigw.CORS(services.Config.CORSAllowedOrigin),
+		apigw.Recover(),
+		apigw.ErrorMapping(),
+		apigw.Deadline(services.Config.DeadlineMargin),
+		apigw.RequireConfig(services),
+		apigw.LimitFailedAuth(services.Limiter),
+		apigw.Authenticate(services.Keys, auth.SCOPE_KEYS_ADMIN),
+		apigw.RateLimit(services.Limiter),
+	)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("apiKeys")...)
+}
@@ -0,0 +1,148 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"ratelimit"
+	"retry"
+	"testing"
+	"context"
+	"strings"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// A fakeDynamoDB instance for mocking test that emulates api keys table
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+const ADMIN_API_KEY = "adminkey.admin_secret"
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 				string
+	Request 			events.APIGatewayProxyRequest
+	ExpectedBody 		string
+	ExpectedStatusCode 	int
+}
+
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	output := new(dynamodb.GetItemOutput)
+
+	if *input.Key["id"].S == "adminkey" {
+		output.SetItem(
+			map[string]*dynamodb.AttributeValue{
+				"id": &dynamodb.AttributeValue{S: aws.String("adminkey")},
+				"secretHash": &dynamodb.AttributeValue{S: aws.String(auth.HashSecret("admin_secret"))},
+				"scopes": &dynamodb.AttributeValue{SS: aws.StringSlice([]string{auth.SCOPE_KEYS_ADMIN})},
+			},
+		)
+	}
+
+	return output, nil
+}
+
+func (fd *FakeDynamoDBAPI) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	return new(dynamodb.PutItemOutput), nil
+}
+
+// only "adminkey" exists, revoking others fails the condition like real DynamoDB
+func (fd *FakeDynamoDBAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if *input.Key["id"].S != "adminkey" {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	return new(dynamodb.UpdateItemOutput), nil
+}
+
+func TestApiKeys(t *testing.T) {
+
+	adminHeaders := map[string]string{"X-Api-Key": ADMIN_API_KEY}
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing non admin caller **",
+			Request:			events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: map[string]string{"X-Api-Key": "otherkey.secret"}},
+			ExpectedBody:		"\"code\": 401",
+			ExpectedStatusCode:	401,
+		},
+		{
+			Name:				"** Testing mint with unknown scope **",
+			Request:			events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: adminHeaders, Body: "{\"name\":\"test\",\"scopes\":[\"devices:delete\"]}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Unknown scope: devices:delete\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing mint with missing fields **",
+			Request:			events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: adminHeaders, Body: "{\"name\":\"test\"}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Following fields are required: name, scopes\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing mint of device key without tenant **",
+			Request:			events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: adminHeaders, Body: "{\"name\":\"test\",\"scopes\":[\"devices:read\"]}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Following fields are required for scope devices:read: tenantId, roles\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing mint with unknown role **",
+			Request:			events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: adminHeaders, Body: "{\"name\":\"test\",\"tenantId\":\"tenant1\",\"scopes\":[\"devices:read\"],\"roles\":[\"superuser\"]}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Unknown role: superuser\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing valid mint **",
+			Request:			events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: adminHeaders, Body: "{\"name\":\"test\",\"tenantId\":\"tenant1\",\"scopes\":[\"devices:read\"],\"roles\":[\"viewer\"]}"},
+			ExpectedBody:		"\"status\": \"api key created\"",
+			ExpectedStatusCode:	201,
+		},
+		{
+			Name:				"** Testing revoke of unknown key **",
+			Request:			events.APIGatewayProxyRequest{HTTPMethod: "DELETE", Headers: adminHeaders, PathParameters: map[string]string{"id": "unknown"}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Desired api key with provided id was not founded\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing valid revoke **",
+			Request:			events.APIGatewayProxyRequest{HTTPMethod: "DELETE", Headers: adminHeaders, PathParameters: map[string]string{"id": "adminkey"}},
+			ExpectedBody:		"{\n\t\"status\": \"api key revoked\"\n}",
+			ExpectedStatusCode:	200,
+		},
+	}
+
+	// api keys table is the only mocked database of this handler
+	cfg := config.Default()
+	cfg.ApiKeysTableName = "test_keys_table_name"
+	fake := &FakeDynamoDBAPI{}
+	handler := newHandler(&apigw.Services{
+		Config:		cfg,
+		DynamoDB:	fake,
+		Keys:		auth.NewKeyStore(fake, cfg.ApiKeysTableName, retry.Default),
+		Limiter:	ratelimit.NewLimiter(cfg.DefaultRateLimit, nil, ""),
+		Retry:		retry.Default,
+	})
+
+	for _, test := range testCases {
+
+		response, _ := handler(context.Background(), test.Request)
+
+		path := "/apikeys"
+		if test.Request.HTTPMethod == "DELETE" {
+			path = "/apikeys/{id}"
+		}
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse(test.Request.HTTPMethod, path, response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode || !strings.Contains(response.Body, test.ExpectedBody) {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // end of TestApiKeys function
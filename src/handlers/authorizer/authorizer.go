@@ -0,0 +1,59 @@
+package main
+
+import (
+	"config"
+	"jwt"
+	"fmt"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// request of a REQUEST type custom authorizer, unlike TOKEN type it contains all headers
+// so requests that only carry an api key can be passed to handlers.
+type AuthorizerRequest struct {
+	Type		string				`json:"type"`
+	MethodArn	string				`json:"methodArn"`
+	Headers		map[string]string	`json:"headers"`
+}
+
+
+// main AWS lambda function starting point.
+// It validates bearer tokens (RS256/ES256 JWTs) of user-facing clients against configured JWKS,
+// selected claims are available to device handlers via request.RequestContext.Authorizer.
+func newAuthorizer(verifier *jwt.Verifier) func(AuthorizerRequest) (events.APIGatewayCustomAuthorizerResponse, error) {
+	return func(request AuthorizerRequest) (events.APIGatewayCustomAuthorizerResponse, error) {
+		return Authorize(verifier, request)
+	}
+}
+
+// Authorize returns an allow policy of the request's method, or jwt.ErrUnauthorized
+func Authorize(verifier *jwt.Verifier, request AuthorizerRequest) (events.APIGatewayCustomAuthorizerResponse, error) {
+	principalId, context, err := jwt.Authorize(verifier, request.Headers)
+	if err != nil {
+		return events.APIGatewayCustomAuthorizerResponse{}, err
+	}
+
+	return events.APIGatewayCustomAuthorizerResponse{
+		PrincipalID: principalId,
+		PolicyDocument: events.APIGatewayCustomAuthorizerPolicy{
+			Version: "2012-10-17",
+			Statement: []events.IAMPolicyStatement{
+				{
+					Action:		[]string{"execute-api:Invoke"},
+					Effect:		"Allow",
+					Resource:	[]string{request.MethodArn},
+				},
+			},
+		},
+		Context: context,
+	}, nil
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Authorizer is started with " + err.Error())
+	}
+	lambda.Start(newAuthorizer(jwt.NewVerifier(cfg)))
+}
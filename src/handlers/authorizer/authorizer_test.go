@@ -0,0 +1,176 @@
+package main
+
+import(
+	"jwt"
+	"time"
+	"testing"
+	"reflect"
+	"math/big"
+	"io/ioutil"
+	"crypto"
+	"crypto/rsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/json"
+	"encoding/base64"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 					string
+	Verifier 				*jwt.Verifier
+	InputRequest 			AuthorizerRequest
+	ExpectedPrincipalID 	string
+	ExpectedContext 		map[string]interface{}
+	ExpectedError 			error
+}
+
+const METHOD_ARN = "arn:aws:execute-api:us-east-1:123456789012:api1/dev/GET/devices"
+
+var signingKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+var otherKey, _ = rsa.GenerateKey(rand.Reader, 2048)
+
+// every test uses a fixed clock, so tokens don't depend on the real time
+var testNow = time.Unix(1530000000, 0)
+
+func encodeSegment(value interface{}) string {
+	encoded, _ := json.Marshal(value)
+	return base64.RawURLEncoding.EncodeToString(encoded)
+}
+
+// signToken returns an RS256 token of claims that is signed by key
+func signToken(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	signingInput := encodeSegment(map[string]string{"alg": "RS256", "kid": kid, "typ": "JWT"}) + "." + encodeSegment(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func claims(exp time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss": "https://issuer.test",
+		"aud": "devices-api",
+		"sub": "user1",
+		"scope": "devices:read",
+		"tenant_id": "tenant1",
+		"roles": []string{"viewer"},
+		"exp": exp.Unix(),
+	}
+}
+
+// verifier of tests, its JWKS is a local file with the public key of signingKey, so no network is needed
+func newTestVerifier(t *testing.T) *jwt.Verifier {
+	jwks := map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA", "kid": "rsa1", "use": "sig", "alg": "RS256",
+				"n": base64.RawURLEncoding.EncodeToString(signingKey.N.Bytes()),
+				"e": base64.RawURLEncoding.EncodeToString(big.NewInt(int64(signingKey.E)).Bytes()),
+			},
+		},
+	}
+	content, _ := json.Marshal(jwks)
+	file, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err.Error())
+	}
+	file.Write(content)
+	file.Close()
+
+	keySet := jwt.NewKeySet(file.Name())
+	keySet.Now = func() time.Time { return testNow }
+	return &jwt.Verifier{
+		Issuer:			"https://issuer.test",
+		Audience:		"devices-api",
+		Keys:			keySet,
+		Now:			func() time.Time { return testNow },
+		ContextClaims:	[]string{"sub", "scope"},
+		TenantClaim:	"tenant_id",
+		RolesClaim:		"roles",
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+
+	verifier := newTestVerifier(t)
+	bearer := func(token string) AuthorizerRequest {
+		return AuthorizerRequest{Type: "REQUEST", MethodArn: METHOD_ARN, Headers: map[string]string{"Authorization": "Bearer " + token}}
+	}
+
+	testCases := []TestCase{
+		{
+			Name:					"** Testing valid bearer token **",
+			Verifier:				verifier,
+			InputRequest:			bearer(signToken(signingKey, "rsa1", claims(testNow.Add(time.Hour)))),
+			ExpectedPrincipalID:	"user1",
+			ExpectedContext:		map[string]interface{}{"authType": jwt.AUTH_TYPE_JWT, "sub": "user1", "scope": "devices:read", "tenantId": "tenant1", "roles": "viewer"},
+		},
+		{
+			Name:					"** Testing request with only an api key **",
+			Verifier:				verifier,
+			InputRequest:			AuthorizerRequest{Type: "REQUEST", MethodArn: METHOD_ARN, Headers: map[string]string{"X-Api-Key": "key1.secret"}},
+			ExpectedPrincipalID:	"apiKey",
+			ExpectedContext:		map[string]interface{}{"authType": jwt.AUTH_TYPE_API_KEY},
+		},
+		{
+			Name:					"** Testing expired token **",
+			Verifier:				verifier,
+			InputRequest:			bearer(signToken(signingKey, "rsa1", claims(testNow.Add(-time.Hour)))),
+			ExpectedError:			jwt.ErrUnauthorized,
+		},
+		{
+			Name:					"** Testing token signed by another key **",
+			Verifier:				verifier,
+			InputRequest:			bearer(signToken(otherKey, "rsa1", claims(testNow.Add(time.Hour)))),
+			ExpectedError:			jwt.ErrUnauthorized,
+		},
+		{
+			Name:					"** Testing token of an unknown kid **",
+			Verifier:				verifier,
+			InputRequest:			bearer(signToken(signingKey, "rsa2", claims(testNow.Add(time.Hour)))),
+			ExpectedError:			jwt.ErrUnauthorized,
+		},
+		{
+			Name:					"** Testing malformed token **",
+			Verifier:				verifier,
+			InputRequest:			bearer("invalid"),
+			ExpectedError:			jwt.ErrUnauthorized,
+		},
+		{
+			Name:					"** Testing request without credentials **",
+			Verifier:				verifier,
+			InputRequest:			AuthorizerRequest{Type: "REQUEST", MethodArn: METHOD_ARN},
+			ExpectedError:			jwt.ErrUnauthorized,
+		},
+		{
+			Name:					"** Testing bearer token without a verifier **",
+			InputRequest:			bearer(signToken(signingKey, "rsa1", claims(testNow.Add(time.Hour)))),
+			ExpectedError:			jwt.ErrUnauthorized,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// calls authorizer.go's handler like lambda does
+		response, err := newAuthorizer(test.Verifier)(test.InputRequest)
+
+		if err != test.ExpectedError {
+			t.Errorf("%s \n \t<expected error: %v> <resulted error: %v>", test.Name, test.ExpectedError, err)
+			continue
+		}
+		if test.ExpectedError != nil {
+			continue
+		}
+
+		// an allowed request gets a policy of its own method only
+		expectedPolicy := events.APIGatewayCustomAuthorizerPolicy{
+			Version: "2012-10-17",
+			Statement: []events.IAMPolicyStatement{{Action: []string{"execute-api:Invoke"}, Effect: "Allow", Resource: []string{METHOD_ARN}}},
+		}
+		if response.PrincipalID != test.ExpectedPrincipalID || !reflect.DeepEqual(response.PolicyDocument, expectedPolicy) || !reflect.DeepEqual(response.Context, test.ExpectedContext) {
+			t.Errorf("%s \n \t<expected principal: %s> <resulted principal: %s> \n \t<expected context: %v> <resulted context: %v> \n \t<resulted policy: %+v>", test.Name, test.ExpectedPrincipalID, response.PrincipalID, test.ExpectedContext, response.Context, response.PolicyDocument)
+		}
+	}
+
+} // end of TestAuthorize function
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"firmware"
+	"policy"
+	"localserver"
+	"metrics"
+	"types"
+	"fmt"
+	"time"
+	"context"
+	"strings"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// campaigns are changed by POST /campaigns/{id}:advance and POST /campaigns/{id}:cancel
+const ADVANCE_SUFFIX = ":advance"
+const CANCEL_SUFFIX = ":cancel"
+
+// reasons of rejected inputs, they are Reason dimension of ValidationFailures metric
+const REASON_EMPTY_BODY = "empty_body"
+const REASON_INVALID_JSON = "invalid_json"
+const REASON_SCHEMA_VIOLATION = "schema_violation"
+
+type SuccessResponse = types.CampaignResponse
+
+// firmware store of the handler, it's built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	Now func() time.Time
+	Firmware *firmware.Store
+}
+
+// main AWS lambda function starting point.
+// POST /campaigns starts a rollout campaign of a firmware version of caller's tenant at its first stage, and
+// POST /campaigns/{id}:advance moves it to its next stage or POST /campaigns/{id}:cancel stops offering its firmware.
+// Devices learn which version to install by GET /devices/{id}/firmware/next.
+func (ig *dynamoDBAPI) Campaigns(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:write scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+	if denied := policy.Check(principal, policy.PERMISSION_FIRMWARE_ROLLOUT); denied != nil {
+		return *denied, nil
+	}
+
+	if id, ok := request.PathParameters["id"]; ok {
+		return ig.changeCampaign(ctx, principal, id)
+	}
+	return ig.startCampaign(ctx, principal, request)
+}
+
+func (ig *dynamoDBAPI) startCampaign(ctx context.Context, principal *auth.Principal, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	campaignRequest, reason, err := validateCampaign(request)
+	if err != nil {
+		metrics.ValidationFailure(ctx, reason)
+		return events.APIGatewayProxyResponse{
+			Body:	err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// campaigns only roll out versions of the catalog, so devices always get a checksum
+	_, err = ig.Firmware.GetFirmware(ctx, principal.TenantID, campaignRequest.DeviceModel, campaignRequest.Version)
+	if err == firmware.ErrFirmwareNotFound {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(400, "Firmware " + campaignRequest.Version + " of " + campaignRequest.DeviceModel + " is not in the catalog, it's added by POST /firmware/{model}"),
+			StatusCode: 400,
+		}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	now := ig.Now().UTC()
+	campaignId, err := firmware.NewID(now)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	campaign := types.Campaign{
+		ID:				campaignId,
+		Name:			campaignRequest.Name,
+		DeviceModel:	campaignRequest.DeviceModel,
+		Version:		campaignRequest.Version,
+		Tags:			campaignRequest.Tags,
+		DeviceStatus:	campaignRequest.DeviceStatus,
+		Stages:			campaignRequest.Stages,
+		Stage:			0,
+		Status:			types.CAMPAIGN_ACTIVE,
+		CreatedAt:		now.Format(time.RFC3339),
+		UpdatedAt:		now.Format(time.RFC3339),
+	}
+	if err := ig.Firmware.PutCampaign(ctx, principal.TenantID, campaign); err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	successResponseJson, _ := json.MarshalIndent(&SuccessResponse{Status: "campaign started", Campaign: campaign}, "", "\t")
+	return events.APIGatewayProxyResponse{
+		Body:	string(successResponseJson),
+		StatusCode: 201,
+	}, nil
+}
+
+func (ig *dynamoDBAPI) changeCampaign(ctx context.Context, principal *auth.Principal, id string) (events.APIGatewayProxyResponse, error) {
+
+	// POST /campaigns/{id} is only routed for the advance and cancel custom methods
+	var action string
+	switch {
+	case strings.HasSuffix(id, ADVANCE_SUFFIX):
+		action = ADVANCE_SUFFIX
+	case strings.HasSuffix(id, CANCEL_SUFFIX):
+		action = CANCEL_SUFFIX
+	default:
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Unknown operation, campaigns are changed by POST /campaigns/{id}" + ADVANCE_SUFFIX + " or POST /campaigns/{id}" + CANCEL_SUFFIX),
+			StatusCode: 404,
+		}, nil
+	}
+	id = strings.TrimSuffix(id, action)
+
+	campaign, err := ig.Firmware.GetCampaign(ctx, principal.TenantID, id)
+	if err == firmware.ErrCampaignNotFound {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Desired campaign with provided id was not founded"),
+			StatusCode: 404,
+		}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	if campaign.Status != types.CAMPAIGN_ACTIVE {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, "Campaign is " + campaign.Status + ", it can't be changed anymore"),
+			StatusCode: 409,
+		}, nil
+	}
+
+	changed := campaign
+	changed.UpdatedAt = ig.Now().UTC().Format(time.RFC3339)
+	status := "campaign cancelled"
+	if action == ADVANCE_SUFFIX {
+		if campaign.Stage >= len(campaign.Stages) - 1 {
+			return events.APIGatewayProxyResponse{
+				Body:	createErrorResponseJson(409, fmt.Sprintf("Campaign is already at its last stage (%d%%)", firmware.Percent(campaign))),
+				StatusCode: 409,
+			}, nil
+		}
+		changed.Stage++
+		status = "campaign advanced"
+	} else {
+		changed.Status = types.CAMPAIGN_CANCELLED
+	}
+
+	// the campaign is only changed from the stage that is read, so a stage isn't skipped by concurrent requests
+	changed, err = ig.Firmware.UpdateCampaign(ctx, principal.TenantID, changed, campaign.Stage)
+	if err == firmware.ErrCampaignChanged {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, "Campaign is changed concurrently, please retry"),
+			StatusCode: 409,
+		}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	successResponseJson, _ := json.MarshalIndent(&SuccessResponse{Status: status, Campaign: changed}, "", "\t")
+	return events.APIGatewayProxyResponse{
+		Body:	string(successResponseJson),
+		StatusCode: 200,
+	}, nil
+}
+
+// validateCampaign returns the campaign of the body, or reason and error body of rejecting it.
+// The body is validated against the campaign schema (GET /schemas/campaign.json).
+func validateCampaign(request events.APIGatewayProxyRequest) (types.CampaignRequest, string, error) {
+	campaignRequest := types.CampaignRequest{}
+	if len(strings.TrimSpace(request.Body)) == 0 {
+		return campaignRequest, REASON_EMPTY_BODY, errors.New(createErrorResponseJson(400, "No inputs provided, please provide inputs in json format."))
+	}
+
+	var body interface{}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return campaignRequest, REASON_INVALID_JSON, errors.New(createErrorResponseJson(400, "Wrong format: Inputs must be a valid json."))
+	}
+
+	if violations := api.ValidateCampaign(body); len(violations) != 0 {
+		errorMessage := "Campaign doesn't match its schema " + api.SCHEMAS_PATH + "campaign.json"
+		return campaignRequest, REASON_SCHEMA_VIOLATION, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
+	}
+	json.Unmarshal([]byte(request.Body), &campaignRequest)
+	return campaignRequest, "", nil
+}
+
+func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
+	return types.NewErrorResponseJson(errorCode, errorMessage)
+}
+
+// newHandler wraps Campaigns with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services. The firmware and campaigns tables are only needed by firmware handlers,
+// so they are checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	problems := []string{}
+	if len(services.Config.FirmwareTableName) == 0 {
+		problems = append(problems, "FIRMWARE_TABLE_NAME is not set")
+	}
+	if len(services.Config.CampaignsTableName) == 0 {
+		problems = append(problems, "CAMPAIGNS_TABLE_NAME is not set")
+	}
+	if len(problems) != 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: problems}
+	}
+	store := &firmware.Store{DynamoDB: services.DynamoDB, FirmwareTable: aws.String(services.Config.FirmwareTableName), CampaignsTable: aws.String(services.Config.CampaignsTableName), Retry: services.Retry}
+	rollouts := &dynamoDBAPI{Now: time.Now, Firmware: store}
+	return apigw.Chain(rollouts.Campaigns, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("campaigns")...)
+}
@@ -0,0 +1,248 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"firmware"
+	"retry"
+	"types"
+	"testing"
+	"context"
+	"time"
+	"errors"
+	"strings"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+	ExpectedStage 				int // stored stage of the campaign of the request
+}
+
+// A fakeDynamoDB instance for mocking test that emulates firmware and campaigns tables of "tenant_test": version
+// "2.1.0" of "thermo-2", and campaigns "c_first" at its first stage, "c_last" at its last stage and "c_cancelled".
+// Its UpdateItem checks the condition of firmware.Store.UpdateCampaign, campaign "c_error" fails.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	campaigns	map[string]map[string]*dynamodb.AttributeValue
+}
+
+func newFakeDynamoDBAPI() *FakeDynamoDBAPI {
+	fd := &FakeDynamoDBAPI{campaigns: map[string]map[string]*dynamodb.AttributeValue{}}
+	stored := []types.Campaign{
+		{ID: "c_first", Name: "sensor fix", DeviceModel: "thermo-2", Version: "2.1.0", Stages: []int{10, 50, 100}, Status: types.CAMPAIGN_ACTIVE, CreatedAt: "2018-06-25T08:00:00Z", UpdatedAt: "2018-06-25T08:00:00Z"},
+		{ID: "c_last", Name: "sensor fix", DeviceModel: "thermo-2", Version: "2.1.0", Stages: []int{10, 100}, Stage: 1, Status: types.CAMPAIGN_ACTIVE, CreatedAt: "2018-06-25T08:00:00Z", UpdatedAt: "2018-06-25T09:00:00Z"},
+		{ID: "c_cancelled", Name: "sensor fix", DeviceModel: "thermo-2", Version: "2.1.0", Stages: []int{10, 100}, Status: types.CAMPAIGN_CANCELLED, CreatedAt: "2018-06-25T08:00:00Z", UpdatedAt: "2018-06-25T09:00:00Z"},
+	}
+	for _, campaign := range stored {
+		item, _ := dynamodbattribute.MarshalMap(campaign)
+		item["tenantId"] = &dynamodb.AttributeValue{S: aws.String("tenant_test")}
+		fd.campaigns[campaign.ID] = item
+	}
+	return fd
+}
+
+// a mocked version of DynamoDB's GetItem function, for both of firmware and campaigns tables
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	output := new(dynamodb.GetItemOutput)
+	if *input.TableName == apigwtest.FIRMWARE_TABLE_NAME {
+		if *input.Key["model"].S == firmware.ModelKey("tenant_test", "thermo-2") && *input.Key["version"].S == "2.1.0" {
+			output.SetItem(map[string]*dynamodb.AttributeValue{"deviceModel": {S: aws.String("thermo-2")}, "version": {S: aws.String("2.1.0")}})
+		}
+		return output, nil
+	}
+
+	id := *input.Key["id"].S
+	if id == "c_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	if *input.Key["tenantId"].S == "tenant_test" {
+		output.SetItem(fd.campaigns[id])
+	}
+	return output, nil
+}
+
+// a mocked version of DynamoDB's PutItem function, it keeps started campaigns
+func (fd *FakeDynamoDBAPI) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	fd.campaigns[*input.Item["id"].S] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// a mocked version of DynamoDB's UpdateItem function, it checks the stage and the status of the condition
+func (fd *FakeDynamoDBAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	item, ok := fd.campaigns[*input.Key["id"].S]
+	values := input.ExpressionAttributeValues
+	if !ok || *item["stage"].N != *values[":from"].N || *item["status"].S != *values[":active"].S {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	item["stage"], item["status"], item["updatedAt"] = values[":stage"], values[":status"], values[":updatedAt"]
+	return &dynamodb.UpdateItemOutput{Attributes: item}, nil
+}
+
+func TestCampaigns(t *testing.T) {
+
+	start := func(body string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}, Body: body}
+	}
+	change := func(id string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}, PathParameters: map[string]string{"id": id}}
+	}
+	errorBody := func(code int, message string) string {
+		return types.NewErrorResponseJson(code, message)
+	}
+
+	// ids of started campaigns are random, they are replaced by <id> in bodies
+	testCases := []TestCase{
+		{
+			Name:				"** Testing starting a campaign **",
+			InputRequest:		start("{\"name\": \"sensor fix\", \"deviceModel\": \"thermo-2\", \"version\": \"2.1.0\", \"tags\": {\"site\": \"berlin\"}, \"stages\": [5, 100]}"),
+			ExpectedBody:		"{\n\t\"status\": \"campaign started\",\n\t\"data\": {\n\t\t\"id\": \"<id>\",\n\t\t\"name\": \"sensor fix\",\n\t\t\"deviceModel\": \"thermo-2\",\n\t\t\"version\": \"2.1.0\",\n\t\t\"tags\": {\n\t\t\t\"site\": \"berlin\"\n\t\t},\n" +
+				"\t\t\"stages\": [\n\t\t\t5,\n\t\t\t100\n\t\t],\n\t\t\"stage\": 0,\n\t\t\"status\": \"active\",\n\t\t\"createdAt\": \"2018-06-26T08:00:00Z\",\n\t\t\"updatedAt\": \"2018-06-26T08:00:00Z\"\n\t}\n}",
+			ExpectedStatusCode:	201,
+		},
+		{
+			Name:				"** Testing version that isn't in the catalog **",
+			InputRequest:		start("{\"name\": \"sensor fix\", \"deviceModel\": \"thermo-2\", \"version\": \"3.0.0\", \"stages\": [100]}"),
+			ExpectedBody:		errorBody(400, "Firmware 3.0.0 of thermo-2 is not in the catalog, it's added by POST /firmware/{model}"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing null stages **",
+			InputRequest:		start("{\"name\": \"sensor fix\", \"deviceModel\": \"thermo-2\", \"version\": \"2.1.0\", \"stages\": null}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Campaign doesn't match its schema /schemas/campaign.json\",\n\t\t\"violations\": [\n\t\t\t{\n\t\t\t\t\"pointer\": \"/stages\",\n\t\t\t\t\"message\": \"must be array\"\n\t\t\t}\n\t\t]\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing decreasing stages **",
+			InputRequest:		start("{\"name\": \"sensor fix\", \"deviceModel\": \"thermo-2\", \"version\": \"2.1.0\", \"stages\": [50, 10]}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Campaign doesn't match its schema /schemas/campaign.json\",\n\t\t\"violations\": [\n\t\t\t{\n\t\t\t\t\"pointer\": \"/stages/1\",\n\t\t\t\t\"message\": \"must be greater than the previous stage\"\n\t\t\t}\n\t\t]\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing empty body **",
+			InputRequest:		start(""),
+			ExpectedBody:		errorBody(400, "No inputs provided, please provide inputs in json format."),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing advancing a campaign **",
+			InputRequest:		change("c_first:advance"),
+			ExpectedBody:		"{\n\t\"status\": \"campaign advanced\",\n\t\"data\": {\n\t\t\"id\": \"c_first\",\n\t\t\"name\": \"sensor fix\",\n\t\t\"deviceModel\": \"thermo-2\",\n\t\t\"version\": \"2.1.0\",\n" +
+				"\t\t\"stages\": [\n\t\t\t10,\n\t\t\t50,\n\t\t\t100\n\t\t],\n\t\t\"stage\": 1,\n\t\t\"status\": \"active\",\n\t\t\"createdAt\": \"2018-06-25T08:00:00Z\",\n\t\t\"updatedAt\": \"2018-06-26T08:00:00Z\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+			ExpectedStage:		1,
+		},
+		{
+			Name:				"** Testing advancing a campaign at its last stage **",
+			InputRequest:		change("c_last:advance"),
+			ExpectedBody:		errorBody(409, "Campaign is already at its last stage (100%)"),
+			ExpectedStatusCode:	409,
+			ExpectedStage:		1,
+		},
+		{
+			Name:				"** Testing cancelling a campaign **",
+			InputRequest:		change("c_last:cancel"),
+			ExpectedBody:		"{\n\t\"status\": \"campaign cancelled\",\n\t\"data\": {\n\t\t\"id\": \"c_last\",\n\t\t\"name\": \"sensor fix\",\n\t\t\"deviceModel\": \"thermo-2\",\n\t\t\"version\": \"2.1.0\",\n" +
+				"\t\t\"stages\": [\n\t\t\t10,\n\t\t\t100\n\t\t],\n\t\t\"stage\": 1,\n\t\t\"status\": \"cancelled\",\n\t\t\"createdAt\": \"2018-06-25T08:00:00Z\",\n\t\t\"updatedAt\": \"2018-06-26T08:00:00Z\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+			ExpectedStage:		1,
+		},
+		{
+			Name:				"** Testing advancing a cancelled campaign **",
+			InputRequest:		change("c_cancelled:advance"),
+			ExpectedBody:		errorBody(409, "Campaign is cancelled, it can't be changed anymore"),
+			ExpectedStatusCode:	409,
+		},
+		{
+			Name:				"** Testing missing campaign **",
+			InputRequest:		change("c_missing:cancel"),
+			ExpectedBody:		errorBody(404, "Desired campaign with provided id was not founded"),
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing change without custom method **",
+			InputRequest:		change("c_first"),
+			ExpectedBody:		errorBody(404, "Unknown operation, campaigns are changed by POST /campaigns/{id}:advance or POST /campaigns/{id}:cancel"),
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		change("c_error:advance"),
+			ExpectedBody:		errorBody(500, "Internal Server's Error occured"),
+			ExpectedStatusCode:	500,
+		},
+		{
+			Name:				"** Testing device advancing a campaign **",
+			InputRequest:		func() events.APIGatewayProxyRequest {
+				request := change("c_first:advance")
+				request.Headers = map[string]string{"X-Api-Key": apigwtest.DEVICE_API_KEY}
+				return request
+			}(),
+			ExpectedBody:		errorBody(403, "Operation is not permitted: none of roles [device] grants firmware:rollout"),
+			ExpectedStatusCode:	403,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases, campaigns are changed at a fixed time
+		now := time.Unix(1530000000, 0)
+		fake := newFakeDynamoDBAPI()
+		store := &firmware.Store{DynamoDB: fake, FirmwareTable: aws.String(apigwtest.FIRMWARE_TABLE_NAME), CampaignsTable: aws.String(apigwtest.CAMPAIGNS_TABLE_NAME), Retry: retry.Default}
+		rollouts := &dynamoDBAPI{Now: func() time.Time { return now }, Firmware: store}
+		handler := apigw.Chain(rollouts.Campaigns, apigw.Standard(apigwtest.NewServices(fake, nil), auth.SCOPE_DEVICES_WRITE)...)
+
+		// calls campaigns.go's Campaigns function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		path := "/campaigns"
+		if _, ok := test.InputRequest.PathParameters["id"]; ok {
+			path = "/campaigns/{id}"
+		}
+		for _, problem := range api.CheckResponse("POST", path, response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		// a started campaign is the only one that isn't in the fake from the beginning
+		campaignId := test.InputRequest.PathParameters["id"]
+		campaignId = strings.TrimSuffix(strings.TrimSuffix(campaignId, ADVANCE_SUFFIX), CANCEL_SUFFIX)
+		body := response.Body
+		if response.StatusCode == 201 {
+			for id := range fake.campaigns {
+				if !strings.HasPrefix(id, "c_") {
+					campaignId = id
+				}
+			}
+			if prefix, _ := firmware.NewID(now); !strings.HasPrefix(campaignId, prefix[:12]) {
+				t.Errorf("%s \n \t<expected id prefix: %s> <resulted id: %s>", test.Name, prefix[:12], campaignId)
+			}
+			body = strings.Replace(body, campaignId, "<id>", 1)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode || body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, body)
+		}
+
+		if item, ok := fake.campaigns[campaignId]; ok {
+			stored := types.Campaign{}
+			dynamodbattribute.UnmarshalMap(item, &stored)
+			if stored.Stage != test.ExpectedStage {
+				t.Errorf("%s \n \t<expected stored stage: %d> <resulted stored stage: %d>", test.Name, test.ExpectedStage, stored.Stage)
+			}
+		}
+	}
+
+} // end of TestCampaigns function
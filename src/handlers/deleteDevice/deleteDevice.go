@@ -0,0 +1,163 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"commands"
+	"config"
+	"devices"
+	"policy"
+	"localserver"
+	"retry"
+	"shadows"
+	"types"
+	"fmt"
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var ErrDeviceNotFound = errors.New("device not found")
+
+type SuccessResponse = types.StatusResponse
+
+// devices table of the handler and the stores of what belongs to a device, they are built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Keys *auth.KeyStore
+	Commands *commands.Store
+	Shadows *shadows.Store
+}
+
+// main AWS lambda function starting point.
+// It deletes a device of caller's tenant with provided id, only admins are allowed to do it.
+// Credentials of the device are revoked and its commands and shadow are deleted with it.
+func (ig *dynamoDBAPI) DeleteDevice(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:write scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+
+	if denied := policy.Check(principal, policy.PERMISSION_DEVICES_DELETE); denied != nil {
+		return *denied, nil
+	}
+
+	id := request.PathParameters["id"]
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "No ID Field Provided"),
+			StatusCode: 404,
+		}, nil
+	}
+
+	exists, err := devices.Exists(ctx, ig.DynamoDB, ig.TableName, ig.Retry, principal.TenantID, id)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	if exists {
+		// a device that is added again with the same id must not get the old credentials, commands or shadow.
+		// They are deleted before the device, so after a failure the device is still there and the request can be sent again.
+		if err := ig.deleteBelongings(ctx, principal.TenantID, id); err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+		err = ig.deleteItemFromDatabase(ctx, principal.TenantID, id)
+	}
+	if !exists || err == ErrDeviceNotFound {
+		devices.LogCrossTenantAccess(ctx, ig.DynamoDB, ig.TableName, principal, id)
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Desired device with provided id was not founded"),
+			StatusCode: 404,
+		}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	successResponseJson, _ := json.MarshalIndent(&SuccessResponse{Status: "requested item deleted"}, "", "\t")
+	return events.APIGatewayProxyResponse{
+		Body:	string(successResponseJson),
+		StatusCode: 200,
+	}, nil
+}
+
+func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
+	return types.NewErrorResponseJson(errorCode, errorMessage)
+}
+
+// function that revokes credentials of a device and deletes its commands and shadow. Commands that are queued
+// while the device is being deleted are left to the TTL of the commands table.
+func (ig *dynamoDBAPI) deleteBelongings(ctx context.Context, tenantId string, id string) error {
+	keys, err := ig.Keys.DeviceKeys(ctx, tenantId, id)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		// a key that is removed in between has nothing left to revoke
+		if err := ig.Keys.RevokeKey(ctx, key); err != nil && err != auth.ErrKeyNotFound {
+			return err
+		}
+	}
+
+	if err := ig.Commands.DeleteAll(ctx, tenantId, id); err != nil {
+		return err
+	}
+	return ig.Shadows.Delete(ctx, tenantId, id)
+}
+
+// function that deletes an existing device of the tenant
+func (ig *dynamoDBAPI) deleteItemFromDatabase(ctx context.Context, tenantId string, id string) error {
+
+	input := &dynamodb.DeleteItemInput{
+		TableName: ig.TableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(id)"),
+	}
+
+	err := ig.Retry.Do(ctx, func() error {
+		_, err := ig.DynamoDB.DeleteItemWithContext(ctx, input)
+		return err
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrDeviceNotFound
+	}
+	return err
+}
+
+// newHandler wraps DeleteDevice with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services. Commands and shadows of deleted devices are deleted too, so their tables are checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	if len(services.Config.ShadowsTableName) == 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: []string{"SHADOWS_TABLE_NAME is not set"}}
+	}
+	if len(services.Config.CommandsTableName) == 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: []string{"COMMANDS_TABLE_NAME is not set"}}
+	}
+	store := &shadows.Store{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.ShadowsTableName), Retry: services.Retry}
+	queue := &commands.Store{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.CommandsTableName), Retry: services.Retry}
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Keys: services.Keys, Commands: queue, Shadows: store}
+	return apigw.Chain(table.DeleteDevice, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("deleteDevice")...)
+}
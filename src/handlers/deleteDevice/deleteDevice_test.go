@@ -0,0 +1,178 @@
+package main
+
+import(
+	"api"
+	"apigwtest"
+	"auth"
+	"types"
+	"errors"
+	"testing"
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// A fakeDynamoDB instance for mocking test that emulates real DynamoDB, "id_test" and "id_failing" of "tenant_test" exist
+// and commands of "id_failing" can't be read. It keeps ids that are checked for cross-tenant access, deleted devices and
+// devices whose commands and shadows are deleted
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	CrossTenantChecks	[]string
+	DeletedDevices		[]string
+	DeletedCommands		[]string
+	DeletedShadows		[]string
+}
+
+// a mocked version of DynamoDB's GetItem function, it's used for checking that the device exists
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	output := new(dynamodb.GetItemOutput)
+	if id := *input.Key["id"].S; *input.Key["tenantId"].S == "tenant_test" && (id == "id_test" || id == "id_failing") {
+		output.SetItem(map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}})
+	}
+	return output, nil
+}
+
+// a mocked version of DynamoDB's Query function, on the id index it's used for logging cross-tenant access attempts
+// (devices of other tenants aren't known) and on the commands table it returns a command of the device
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	if *input.TableName == apigwtest.COMMANDS_TABLE_NAME {
+		device := input.ExpressionAttributeValues[":device"]
+		if *device.S == "tenant_test#id_failing" {
+			return nil, errors.New("commands table is not available")
+		}
+		return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{{"device": device, "id": {S: aws.String("command_test")}}}}, nil
+	}
+	if *input.IndexName == types.DEVICES_ID_INDEX {
+		fd.CrossTenantChecks = append(fd.CrossTenantChecks, *input.ExpressionAttributeValues[":id"].S)
+	}
+	return &dynamodb.QueryOutput{}, nil
+}
+
+// a mocked version of DynamoDB's BatchWriteItem function, every command is deleted
+func (fd *FakeDynamoDBAPI) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	for _, request := range input.RequestItems[apigwtest.COMMANDS_TABLE_NAME] {
+		fd.DeletedCommands = append(fd.DeletedCommands, *request.DeleteRequest.Key["device"].S + "/" + *request.DeleteRequest.Key["id"].S)
+	}
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+// A fake DynamoDB for api keys table, a key of "id_test" device is in its device index. It keeps the keys that are revoked
+type FakeKeysDynamoDBAPI struct {
+	apigwtest.FakeKeysDynamoDBAPI
+	RevokedKeys	[]string
+}
+
+// a mocked version of DynamoDB's Query function on the device index of api keys
+func (fd *FakeKeysDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	output := &dynamodb.QueryOutput{}
+	if *input.IndexName == auth.DEVICE_INDEX && *input.ExpressionAttributeValues[":device"].S == "tenant_test#id_test" {
+		output.Items = []map[string]*dynamodb.AttributeValue{{"id": {S: aws.String("devicekey")}}}
+	}
+	return output, nil
+}
+
+// a mocked version of DynamoDB's UpdateItem function, it's used for revoking api keys
+func (fd *FakeKeysDynamoDBAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	fd.RevokedKeys = append(fd.RevokedKeys, *input.Key["id"].S)
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 				string
+	Request 			events.APIGatewayProxyRequest
+	ExpectedBody 		string
+	ExpectedStatusCode 	int
+}
+
+// a mocked version of DynamoDB's DeleteItem function, only "id_test" and "id_failing" of "tenant_test" exist.
+func (fd *FakeDynamoDBAPI) DeleteItemWithContext(ctx aws.Context, input *dynamodb.DeleteItemInput, opts ...request.Option) (*dynamodb.DeleteItemOutput, error) {
+	if *input.TableName == apigwtest.SHADOWS_TABLE_NAME {
+		fd.DeletedShadows = append(fd.DeletedShadows, *input.Key["device"].S)
+		return new(dynamodb.DeleteItemOutput), nil
+	}
+	if id := *input.Key["id"].S; *input.Key["tenantId"].S != "tenant_test" || id != "id_test" && id != "id_failing" {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	fd.DeletedDevices = append(fd.DeletedDevices, *input.Key["id"].S)
+	return new(dynamodb.DeleteItemOutput), nil
+}
+
+func TestDeleteDevice(t *testing.T) {
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing operator deleting **",
+			Request:			events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}, PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 403,\n\t\t\"message\": \"Operation is not permitted: none of roles [operator] grants devices:delete\"\n\t}\n}",
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name:				"** Testing not existing device **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test_no"}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Desired device with provided id was not founded\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing admin deleting **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:		"{\n\t\"status\": \"requested item deleted\"\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing device whose commands can't be deleted **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_failing"}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}",
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	// create mocked databases.
+	fake := &FakeDynamoDBAPI{}
+	keys := &FakeKeysDynamoDBAPI{}
+	handler := newHandler(apigwtest.NewServices(fake, keys))
+
+	for _, test := range testCases {
+
+		// requests without explicit headers are sent with an admin key
+		if test.Request.Headers == nil {
+			test.Request.Headers = map[string]string{"X-Api-Key": apigwtest.ADMIN_API_KEY}
+		}
+
+		response, _ := handler(context.Background(), test.Request)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("DELETE", "/devices/{id}", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+
+	// only the device that isn't found in caller's tenant is checked for cross-tenant access
+	if len(fake.CrossTenantChecks) != 1 || fake.CrossTenantChecks[0] != "id_test_no" {
+		t.Errorf("** Testing cross-tenant access check ** \n \t<expected checks: [id_test_no]> <resulted checks: %v>", fake.CrossTenantChecks)
+	}
+
+	// a device whose commands can't be deleted is kept, so the request can be sent again
+	if len(fake.DeletedDevices) != 1 || fake.DeletedDevices[0] != "id_test" {
+		t.Errorf("** Testing deleted devices ** \n \t<expected deleted devices: [id_test]> <resulted deleted devices: %v>", fake.DeletedDevices)
+	}
+
+	// only the key, commands and shadow of the deleted device are revoked or deleted
+	if len(keys.RevokedKeys) != 1 || keys.RevokedKeys[0] != "devicekey" {
+		t.Errorf("** Testing keys of deleted device ** \n \t<expected revoked keys: [devicekey]> <resulted revoked keys: %v>", keys.RevokedKeys)
+	}
+	if len(fake.DeletedCommands) != 1 || fake.DeletedCommands[0] != "tenant_test#id_test/command_test" {
+		t.Errorf("** Testing commands of deleted device ** \n \t<expected deleted commands: [tenant_test#id_test/command_test]> <resulted deleted commands: %v>", fake.DeletedCommands)
+	}
+	if len(fake.DeletedShadows) != 1 || fake.DeletedShadows[0] != "tenant_test#id_test" {
+		t.Errorf("** Testing shadow of deleted device ** \n \t<expected deleted shadows: [tenant_test#id_test]> <resulted deleted shadows: %v>", fake.DeletedShadows)
+	}
+} // end of TestDeleteDevice function
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"commands"
+	"config"
+	"devices"
+	"policy"
+	"localserver"
+	"metrics"
+	"retry"
+	"schema"
+	"types"
+	"fmt"
+	"time"
+	"context"
+	"strings"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// commands are acked by POST /devices/{id}/commands/{cmdId}:ack
+const ACK_SUFFIX = ":ack"
+
+// expiry of commands without expiresIn, and the shortest and longest one that can be sent
+const DEFAULT_EXPIRY = time.Hour
+const MIN_EXPIRY = time.Minute
+const MAX_EXPIRY = 7 * 24 * time.Hour
+
+// how many times a command is read again when it's changed concurrently
+const MAX_CONFLICT_RETRIES = 3
+
+var ErrDeviceNotFound = errors.New("device not found")
+var ErrTooManyConflicts = errors.New("command is changed concurrently too many times")
+
+// reasons of rejected inputs, they are Reason dimension of ValidationFailures metric
+const REASON_EMPTY_BODY = "empty_body"
+const REASON_INVALID_JSON = "invalid_json"
+const REASON_SCHEMA_VIOLATION = "schema_violation"
+
+type SuccessResponse = types.CommandResponse
+
+// devices table and commands store of the handler, they are built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Now func() time.Time
+	Commands *commands.Store
+}
+
+// main AWS lambda function starting point.
+// POST /devices/{id}/commands queues a command for a device of caller's tenant and POST /devices/{id}/commands/{cmdId}:ack
+// reports its result. Commands that aren't acked before they expire become expired and can't be acked anymore.
+func (ig *dynamoDBAPI) DeviceCommands(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:write scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+
+	id := request.PathParameters["id"]
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "No ID Field Provided"),
+			StatusCode: 404,
+		}, nil
+	}
+
+	if commandId, ok := request.PathParameters["cmdId"]; ok {
+		return ig.ackCommand(ctx, principal, id, commandId, request)
+	}
+	return ig.queueCommand(ctx, principal, id, request)
+}
+
+func (ig *dynamoDBAPI) queueCommand(ctx context.Context, principal *auth.Principal, id string, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if denied := policy.Check(principal, policy.PERMISSION_COMMANDS_SEND); denied != nil {
+		return *denied, nil
+	}
+
+	commandRequest, expiry, reason, err := validateCommand(request)
+	if err != nil {
+		metrics.ValidationFailure(ctx, reason)
+		return events.APIGatewayProxyResponse{
+			Body:	err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	status, err := ig.deviceStatus(ctx, principal.TenantID, id)
+	if err == ErrDeviceNotFound {
+		devices.LogCrossTenantAccess(ctx, ig.DynamoDB, ig.TableName, principal, id)
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Desired device with provided id was not founded"),
+			StatusCode: 404,
+		}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	if status == types.STATUS_RETIRED {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, "Commands can't be sent to retired devices"),
+			StatusCode: 409,
+		}, nil
+	}
+
+	now := ig.Now().UTC()
+	commandId, err := commands.NewID(now)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	command := types.Command{
+		ID:			commandId,
+		Name:		commandRequest.Name,
+		Parameters:	commandRequest.Parameters,
+		Status:		types.COMMAND_QUEUED,
+		CreatedAt:	now.Format(time.RFC3339),
+		ExpiresAt:	now.Add(expiry).Format(time.RFC3339),
+	}
+	if err := ig.Commands.Put(ctx, principal.TenantID, id, command); err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	successResponseJson, _ := json.MarshalIndent(&SuccessResponse{Status: "command queued", Command: command}, "", "\t")
+	return events.APIGatewayProxyResponse{
+		Body:	string(successResponseJson),
+		StatusCode: 201,
+	}, nil
+}
+
+func (ig *dynamoDBAPI) ackCommand(ctx context.Context, principal *auth.Principal, id string, commandId string, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// POST /devices/{id}/commands/{cmdId} is only routed for the ack custom method
+	if !strings.HasSuffix(commandId, ACK_SUFFIX) {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Unknown operation, commands are acked by POST /devices/{id}/commands/{cmdId}" + ACK_SUFFIX),
+			StatusCode: 404,
+		}, nil
+	}
+	commandId = strings.TrimSuffix(commandId, ACK_SUFFIX)
+
+	if denied := policy.Check(principal, policy.PERMISSION_COMMANDS_RECEIVE); denied != nil {
+		return *denied, nil
+	}
+
+	ack, reason, err := validateAck(request)
+	if err != nil {
+		metrics.ValidationFailure(ctx, reason)
+		return events.APIGatewayProxyResponse{
+			Body:	err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	command, changed, err := ig.acknowledge(ctx, principal.TenantID, id, commandId, ack)
+	switch {
+	case err == commands.ErrCommandNotFound:
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Desired command with provided id was not founded"),
+			StatusCode: 404,
+		}, nil
+	case err == ErrTooManyConflicts:
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, "Command is changed concurrently, please retry"),
+			StatusCode: 409,
+		}, nil
+	case err != nil:
+		return events.APIGatewayProxyResponse{}, err
+	case command.Status == types.COMMAND_EXPIRED:
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, "Command is expired at " + command.ExpiresAt + ", it can't be acked anymore"),
+			StatusCode: 409,
+		}, nil
+	case command.Status != ack.Status:
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, "Command is already acked as " + command.Status),
+			StatusCode: 409,
+		}, nil
+	}
+
+	// an ack that is sent again gets the command as it's acked first, so retried requests succeed too
+	status := "command acked"
+	if !changed {
+		status = "command is already acked"
+	}
+	successResponseJson, _ := json.MarshalIndent(&SuccessResponse{Status: status, Command: command}, "", "\t")
+	return events.APIGatewayProxyResponse{
+		Body:	string(successResponseJson),
+		StatusCode: 200,
+	}, nil
+}
+
+// acknowledge sets status and result of ack to a queued or delivered command. Finished commands (including the ones
+// that are found expired) are returned as they are with changed false.
+func (ig *dynamoDBAPI) acknowledge(ctx context.Context, tenantId string, id string, commandId string, ack types.CommandAckRequest) (types.Command, bool, error) {
+	for attempt := 0; attempt < MAX_CONFLICT_RETRIES; attempt++ {
+		now := ig.Now().UTC()
+		command, err := ig.Commands.Get(ctx, tenantId, id, commandId)
+		if err == nil {
+			command, err = ig.Commands.Settle(ctx, tenantId, id, command, now)
+		}
+		if err != nil {
+			return types.Command{}, false, err
+		}
+		if commands.IsFinished(command.Status) {
+			return command, false, nil
+		}
+
+		acked := command
+		acked.Status = ack.Status
+		acked.CompletedAt = now.Format(time.RFC3339)
+		acked.Result = ack.Result
+		acked, err = ig.Commands.Transition(ctx, tenantId, id, acked, now, types.COMMAND_QUEUED, types.COMMAND_DELIVERED)
+		if err == commands.ErrStatusChanged {
+			continue
+		}
+		return acked, err == nil, err
+	}
+	return types.Command{}, false, ErrTooManyConflicts
+}
+
+// validateCommand returns the command of the body and its expiry, or reason and error body of rejecting them.
+// The body is validated against the command schema (GET /schemas/command.json).
+func validateCommand(request events.APIGatewayProxyRequest) (types.CommandRequest, time.Duration, string, error) {
+	commandRequest := types.CommandRequest{}
+	if len(strings.TrimSpace(request.Body)) == 0 {
+		return commandRequest, 0, REASON_EMPTY_BODY, errors.New(createErrorResponseJson(400, "No inputs provided, please provide inputs in json format."))
+	}
+
+	var body interface{}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return commandRequest, 0, REASON_INVALID_JSON, errors.New(createErrorResponseJson(400, "Wrong format: Inputs must be a valid json."))
+	}
+
+	expiry := DEFAULT_EXPIRY
+	violations := api.ValidateCommand(body)
+	if len(violations) == 0 {
+		json.Unmarshal([]byte(request.Body), &commandRequest)
+		if len(commandRequest.ExpiresIn) != 0 {
+			expiry, _ = time.ParseDuration(commandRequest.ExpiresIn)
+			if expiry < MIN_EXPIRY || expiry > MAX_EXPIRY {
+				violations = append(violations, schema.Violation{Pointer: "/expiresIn", Message: fmt.Sprintf("must be from %s to %s", MIN_EXPIRY, MAX_EXPIRY)})
+			}
+		}
+	}
+
+	if len(violations) != 0 {
+		errorMessage := "Command doesn't match its schema " + api.SCHEMAS_PATH + "command.json"
+		return types.CommandRequest{}, 0, REASON_SCHEMA_VIOLATION, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
+	}
+	return commandRequest, expiry, "", nil
+}
+
+// validateAck returns the ack of the body, or reason and error body of rejecting it.
+// The body is validated against the command ack schema (GET /schemas/command-ack.json).
+func validateAck(request events.APIGatewayProxyRequest) (types.CommandAckRequest, string, error) {
+	ack := types.CommandAckRequest{}
+	if len(strings.TrimSpace(request.Body)) == 0 {
+		return ack, REASON_EMPTY_BODY, errors.New(createErrorResponseJson(400, "No inputs provided, please provide inputs in json format."))
+	}
+
+	var body interface{}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return ack, REASON_INVALID_JSON, errors.New(createErrorResponseJson(400, "Wrong format: Inputs must be a valid json."))
+	}
+
+	if violations := api.ValidateCommandAck(body); len(violations) != 0 {
+		errorMessage := "Command ack doesn't match its schema " + api.SCHEMAS_PATH + "command-ack.json"
+		return ack, REASON_SCHEMA_VIOLATION, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
+	}
+	json.Unmarshal([]byte(request.Body), &ack)
+	return ack, "", nil
+}
+
+func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
+	return types.NewErrorResponseJson(errorCode, errorMessage)
+}
+
+// function that returns status of a device of the tenant, ErrDeviceNotFound when it doesn't exist.
+// only id and status of the device are read, devices stored before statuses existed are provisioned.
+func (ig *dynamoDBAPI) deviceStatus(ctx context.Context, tenantId string, id string) (string, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: ig.TableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ProjectionExpression: aws.String("id, #status"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+	}
+
+	var output *dynamodb.GetItemOutput
+	err := ig.Retry.Do(ctx, func() (err error) {
+		output, err = ig.DynamoDB.GetItemWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(output.Item) == 0 {
+		return "", ErrDeviceNotFound
+	}
+	if output.Item["status"] == nil || output.Item["status"].S == nil {
+		return types.STATUS_PROVISIONED, nil
+	}
+	return *output.Item["status"].S, nil
+}
+
+// newHandler wraps DeviceCommands with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services. The commands table is only needed by command handlers, so it's checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	if len(services.Config.CommandsTableName) == 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: []string{"COMMANDS_TABLE_NAME is not set"}}
+	}
+	store := &commands.Store{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.CommandsTableName), Retry: services.Retry}
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Now: time.Now, Commands: store}
+	return apigw.Chain(table.DeviceCommands, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("deviceCommands")...)
+}
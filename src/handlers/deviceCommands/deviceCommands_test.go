@@ -0,0 +1,318 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"commands"
+	"retry"
+	"types"
+	"testing"
+	"context"
+	"time"
+	"errors"
+	"strings"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+	ExpectedStatus 				string // stored status of the command of the request, or of the queued command
+}
+
+// A fakeDynamoDB instance for mocking test that emulates real DynamoDB with devices "id_test" and "id_retired" of
+// "tenant_test", and commands of "id_test": "c_delivered" and "c_queued" that expire at 09:00, "c_succeeded" and
+// "c_late" that expired at 07:30 without being acked. Its UpdateItem checks conditions of commands.Store.Transition.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	commands	map[string]map[string]*dynamodb.AttributeValue
+}
+
+// a mocked version of DynamoDB's Query function on the id index, it's used for logging cross-tenant access
+// attempts and devices of other tenants aren't known
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{}, nil
+}
+
+func newFakeDynamoDBAPI() *FakeDynamoDBAPI {
+	fd := &FakeDynamoDBAPI{commands: map[string]map[string]*dynamodb.AttributeValue{}}
+	stored := []types.Command{
+		{ID: "c_delivered", Name: "reboot", Status: types.COMMAND_DELIVERED, CreatedAt: "2018-06-26T07:00:00Z", ExpiresAt: "2018-06-26T09:00:00Z", DeliveredAt: "2018-06-26T07:01:00Z"},
+		{ID: "c_queued", Name: "reboot", Status: types.COMMAND_QUEUED, CreatedAt: "2018-06-26T07:00:00Z", ExpiresAt: "2018-06-26T09:00:00Z"},
+		{ID: "c_succeeded", Name: "reboot", Status: types.COMMAND_SUCCEEDED, CreatedAt: "2018-06-26T06:00:00Z", ExpiresAt: "2018-06-26T07:00:00Z", DeliveredAt: "2018-06-26T06:01:00Z", CompletedAt: "2018-06-26T06:02:00Z"},
+		{ID: "c_late", Name: "recalibrate", Status: types.COMMAND_DELIVERED, CreatedAt: "2018-06-26T06:30:00Z", ExpiresAt: "2018-06-26T07:30:00Z", DeliveredAt: "2018-06-26T06:31:00Z"},
+	}
+	for _, command := range stored {
+		item, _ := dynamodbattribute.MarshalMap(command)
+		item["device"] = &dynamodb.AttributeValue{S: aws.String(commands.DeviceKey("tenant_test", "id_test"))}
+		fd.commands[command.ID] = item
+	}
+	return fd
+}
+
+// a mocked version of DynamoDB's GetItem function, for both of devices and commands tables
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	output := new(dynamodb.GetItemOutput)
+	id := *input.Key["id"].S
+	if id == "id_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+
+	if *input.TableName == apigwtest.COMMANDS_TABLE_NAME {
+		if *input.Key["device"].S == commands.DeviceKey("tenant_test", "id_test") {
+			output.SetItem(fd.commands[id])
+		}
+		return output, nil
+	}
+
+	if *input.Key["tenantId"].S == "tenant_test" {
+		switch id {
+		case "id_test":
+			output.SetItem(map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}})
+		case "id_retired":
+			output.SetItem(map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}, "status": {S: aws.String(types.STATUS_RETIRED)}})
+		}
+	}
+	return output, nil
+}
+
+// a mocked version of DynamoDB's PutItem function, it keeps queued commands
+func (fd *FakeDynamoDBAPI) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	id := *input.Item["id"].S
+	if _, ok := fd.commands[id]; ok {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	fd.commands[id] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// a mocked version of DynamoDB's UpdateItem function, it checks the allowed statuses and the expiry of the condition
+func (fd *FakeDynamoDBAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	failed := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	item, ok := fd.commands[*input.Key["id"].S]
+	if !ok {
+		return nil, failed
+	}
+
+	allowed := false
+	for name, value := range input.ExpressionAttributeValues {
+		if strings.HasPrefix(name, ":from") && *value.S == *item["status"].S {
+			allowed = true
+		}
+	}
+	due := *item["expiresAt"].S <= *input.ExpressionAttributeValues[":now"].S
+	if !allowed || due != strings.Contains(*input.ConditionExpression, "<=") {
+		return nil, failed
+	}
+
+	for _, name := range []string{"status", "deliveredAt", "completedAt", "result"} {
+		if value, ok := input.ExpressionAttributeValues[":" + name]; ok {
+			item[name] = value
+		}
+	}
+	return &dynamodb.UpdateItemOutput{Attributes: item}, nil
+}
+
+func TestDeviceCommands(t *testing.T) {
+
+	queue := func(id string, body string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}, PathParameters: map[string]string{"id": id}, Body: body}
+	}
+	ack := func(commandId string, body string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: map[string]string{"X-Api-Key": apigwtest.DEVICE_API_KEY}, PathParameters: map[string]string{"id": "id_test", "cmdId": commandId}, Body: body}
+	}
+	errorBody := func(code int, message string) string {
+		return types.NewErrorResponseJson(code, message)
+	}
+
+	// ids of queued commands are random, they are replaced by <id> in bodies
+	testCases := []TestCase{
+		{
+			Name:				"** Testing queueing a command **",
+			InputRequest:		queue("id_test", "{\"name\": \"recalibrate\", \"parameters\": {\"axis\": \"x\"}}"),
+			ExpectedBody:		"{\n\t\"status\": \"command queued\",\n\t\"data\": {\n\t\t\"id\": \"<id>\",\n\t\t\"name\": \"recalibrate\",\n\t\t\"parameters\": {\n\t\t\t\"axis\": \"x\"\n\t\t},\n\t\t\"status\": \"queued\",\n\t\t\"createdAt\": \"2018-06-26T08:00:00Z\",\n\t\t\"expiresAt\": \"2018-06-26T09:00:00Z\"\n\t}\n}",
+			ExpectedStatusCode:	201,
+			ExpectedStatus:		types.COMMAND_QUEUED,
+		},
+		{
+			Name:				"** Testing queueing a command with expiry **",
+			InputRequest:		queue("id_test", "{\"name\": \"reboot\", \"expiresIn\": \"15m\"}"),
+			ExpectedBody:		"{\n\t\"status\": \"command queued\",\n\t\"data\": {\n\t\t\"id\": \"<id>\",\n\t\t\"name\": \"reboot\",\n\t\t\"status\": \"queued\",\n\t\t\"createdAt\": \"2018-06-26T08:00:00Z\",\n\t\t\"expiresAt\": \"2018-06-26T08:15:00Z\"\n\t}\n}",
+			ExpectedStatusCode:	201,
+			ExpectedStatus:		types.COMMAND_QUEUED,
+		},
+		{
+			Name:				"** Testing invalid name **",
+			InputRequest:		queue("id_test", "{\"name\": \"re boot\"}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Command doesn't match its schema /schemas/command.json\",\n\t\t\"violations\": [\n\t\t\t{\n\t\t\t\t\"pointer\": \"/name\",\n\t\t\t\t\"message\": \"must match pattern ^[A-Za-z0-9_.-]+$\"\n\t\t\t}\n\t\t]\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing too short expiry **",
+			InputRequest:		queue("id_test", "{\"name\": \"reboot\", \"expiresIn\": \"10s\"}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Command doesn't match its schema /schemas/command.json\",\n\t\t\"violations\": [\n\t\t\t{\n\t\t\t\t\"pointer\": \"/expiresIn\",\n\t\t\t\t\"message\": \"must be from 1m0s to 168h0m0s\"\n\t\t\t}\n\t\t]\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing empty body **",
+			InputRequest:		queue("id_test", ""),
+			ExpectedBody:		errorBody(400, "No inputs provided, please provide inputs in json format."),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing retired device **",
+			InputRequest:		queue("id_retired", "{\"name\": \"reboot\"}"),
+			ExpectedBody:		errorBody(409, "Commands can't be sent to retired devices"),
+			ExpectedStatusCode:	409,
+		},
+		{
+			Name:				"** Testing missing device **",
+			InputRequest:		queue("id_missing", "{\"name\": \"reboot\"}"),
+			ExpectedBody:		errorBody(404, "Desired device with provided id was not founded"),
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		queue("id_error", "{\"name\": \"reboot\"}"),
+			ExpectedBody:		errorBody(500, "Internal Server's Error occured"),
+			ExpectedStatusCode:	500,
+		},
+		{
+			Name:				"** Testing device queueing a command **",
+			InputRequest:		func() events.APIGatewayProxyRequest {
+				request := queue("id_test", "{\"name\": \"reboot\"}")
+				request.Headers = map[string]string{"X-Api-Key": apigwtest.DEVICE_API_KEY}
+				return request
+			}(),
+			ExpectedBody:		errorBody(403, "Operation is not permitted: none of roles [device] grants commands:send"),
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name:				"** Testing ack of a delivered command **",
+			InputRequest:		ack("c_delivered:ack", "{\"status\": \"succeeded\", \"result\": {\"uptime\": 3}}"),
+			ExpectedBody:		"{\n\t\"status\": \"command acked\",\n\t\"data\": {\n\t\t\"id\": \"c_delivered\",\n\t\t\"name\": \"reboot\",\n\t\t\"status\": \"succeeded\",\n\t\t\"createdAt\": \"2018-06-26T07:00:00Z\",\n\t\t\"expiresAt\": \"2018-06-26T09:00:00Z\",\n\t\t\"deliveredAt\": \"2018-06-26T07:01:00Z\",\n\t\t\"completedAt\": \"2018-06-26T08:00:00Z\",\n\t\t\"result\": {\n\t\t\t\"uptime\": 3\n\t\t}\n\t}\n}",
+			ExpectedStatusCode:	200,
+			ExpectedStatus:		types.COMMAND_SUCCEEDED,
+		},
+		{
+			Name:				"** Testing ack of a queued command **",
+			InputRequest:		ack("c_queued:ack", "{\"status\": \"failed\"}"),
+			ExpectedBody:		"{\n\t\"status\": \"command acked\",\n\t\"data\": {\n\t\t\"id\": \"c_queued\",\n\t\t\"name\": \"reboot\",\n\t\t\"status\": \"failed\",\n\t\t\"createdAt\": \"2018-06-26T07:00:00Z\",\n\t\t\"expiresAt\": \"2018-06-26T09:00:00Z\",\n\t\t\"completedAt\": \"2018-06-26T08:00:00Z\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+			ExpectedStatus:		types.COMMAND_FAILED,
+		},
+		{
+			Name:				"** Testing ack that is sent again **",
+			InputRequest:		ack("c_succeeded:ack", "{\"status\": \"succeeded\"}"),
+			ExpectedBody:		"{\n\t\"status\": \"command is already acked\",\n\t\"data\": {\n\t\t\"id\": \"c_succeeded\",\n\t\t\"name\": \"reboot\",\n\t\t\"status\": \"succeeded\",\n\t\t\"createdAt\": \"2018-06-26T06:00:00Z\",\n\t\t\"expiresAt\": \"2018-06-26T07:00:00Z\",\n\t\t\"deliveredAt\": \"2018-06-26T06:01:00Z\",\n\t\t\"completedAt\": \"2018-06-26T06:02:00Z\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+			ExpectedStatus:		types.COMMAND_SUCCEEDED,
+		},
+		{
+			Name:				"** Testing ack of another result **",
+			InputRequest:		ack("c_succeeded:ack", "{\"status\": \"failed\"}"),
+			ExpectedBody:		errorBody(409, "Command is already acked as succeeded"),
+			ExpectedStatusCode:	409,
+			ExpectedStatus:		types.COMMAND_SUCCEEDED,
+		},
+		{
+			Name:				"** Testing ack of an expired command **",
+			InputRequest:		ack("c_late:ack", "{\"status\": \"succeeded\"}"),
+			ExpectedBody:		errorBody(409, "Command is expired at 2018-06-26T07:30:00Z, it can't be acked anymore"),
+			ExpectedStatusCode:	409,
+			ExpectedStatus:		types.COMMAND_EXPIRED,
+		},
+		{
+			Name:				"** Testing ack of a missing command **",
+			InputRequest:		ack("c_missing:ack", "{\"status\": \"succeeded\"}"),
+			ExpectedBody:		errorBody(404, "Desired command with provided id was not founded"),
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing ack without custom method **",
+			InputRequest:		ack("c_delivered", "{\"status\": \"succeeded\"}"),
+			ExpectedBody:		errorBody(404, "Unknown operation, commands are acked by POST /devices/{id}/commands/{cmdId}:ack"),
+			ExpectedStatusCode:	404,
+			ExpectedStatus:		types.COMMAND_DELIVERED,
+		},
+		{
+			Name:				"** Testing invalid ack status **",
+			InputRequest:		ack("c_delivered:ack", "{\"status\": \"expired\"}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Command ack doesn't match its schema /schemas/command-ack.json\",\n\t\t\"violations\": [\n\t\t\t{\n\t\t\t\t\"pointer\": \"/status\",\n\t\t\t\t\"message\": \"must be one of [\\\"succeeded\\\",\\\"failed\\\"]\"\n\t\t\t}\n\t\t]\n\t}\n}",
+			ExpectedStatusCode:	400,
+			ExpectedStatus:		types.COMMAND_DELIVERED,
+		},
+		{
+			Name:				"** Testing operator acking a command **",
+			InputRequest:		func() events.APIGatewayProxyRequest {
+				request := ack("c_delivered:ack", "{\"status\": \"succeeded\"}")
+				request.Headers = map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}
+				return request
+			}(),
+			ExpectedBody:		errorBody(403, "Operation is not permitted: none of roles [operator] grants commands:receive"),
+			ExpectedStatusCode:	403,
+			ExpectedStatus:		types.COMMAND_DELIVERED,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases, commands are queued and acked at a fixed time
+		now := time.Unix(1530000000, 0)
+		fake := newFakeDynamoDBAPI()
+		store := &commands.Store{DynamoDB: fake, TableName: aws.String(apigwtest.COMMANDS_TABLE_NAME), Retry: retry.Default}
+		devices := &dynamoDBAPI{DynamoDB: fake, TableName: aws.String("test_table_name"), Retry: retry.Default, Now: func() time.Time { return now }, Commands: store}
+		handler := apigw.Chain(devices.DeviceCommands, apigw.Standard(apigwtest.NewServices(fake, nil), auth.SCOPE_DEVICES_WRITE)...)
+
+		// calls deviceCommands.go's DeviceCommands function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		path := "/devices/{id}/commands"
+		if _, ok := test.InputRequest.PathParameters["cmdId"]; ok {
+			path = "/devices/{id}/commands/{cmdId}"
+		}
+		for _, problem := range api.CheckResponse("POST", path, response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		// a queued command is the only one that isn't in the fake from the beginning
+		commandId := strings.TrimSuffix(test.InputRequest.PathParameters["cmdId"], ACK_SUFFIX)
+		body := response.Body
+		if response.StatusCode == 201 {
+			for id := range fake.commands {
+				if !strings.HasPrefix(id, "c_") {
+					commandId = id
+				}
+			}
+			if prefix, _ := commands.NewID(now); !strings.HasPrefix(commandId, prefix[:12]) {
+				t.Errorf("%s \n \t<expected id prefix: %s> <resulted id: %s>", test.Name, prefix[:12], commandId)
+			}
+			body = strings.Replace(body, commandId, "<id>", 1)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode || body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, body)
+		}
+
+		if len(test.ExpectedStatus) != 0 {
+			stored := types.Command{}
+			dynamodbattribute.UnmarshalMap(fake.commands[commandId], &stored)
+			if stored.Status != test.ExpectedStatus {
+				t.Errorf("%s \n \t<expected stored status: %s> <resulted stored status: %s>", test.Name, test.ExpectedStatus, stored.Status)
+			}
+		}
+	}
+
+} // end of TestDeviceCommands function
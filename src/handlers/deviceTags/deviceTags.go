@@ -0,0 +1,333 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"devices"
+	"policy"
+	"localserver"
+	"metrics"
+	"retry"
+	"tracing"
+	"types"
+	"fmt"
+	"context"
+	"reflect"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// how many times tags are read again when the device is changed concurrently
+const MAX_CONFLICT_RETRIES = 3
+
+var ErrDeviceNotFound = errors.New("device not found")
+var ErrDeviceRetired = errors.New("device is retired")
+var ErrTooManyConflicts = errors.New("device is changed concurrently too many times")
+var ErrTooManyTags = fmt.Errorf("A device can have at most %d tags", types.MAX_TAGS)
+
+// reasons of rejected inputs, they are Reason dimension of ValidationFailures metric
+const REASON_EMPTY_BODY = "empty_body"
+const REASON_INVALID_JSON = "invalid_json"
+const REASON_SCHEMA_VIOLATION = "schema_violation"
+const REASON_TOO_MANY_TAGS = "too_many_tags"
+
+type SuccessResponse = types.DeviceResponse
+
+// devices table of the handler, it's built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+}
+
+// main AWS lambda function starting point.
+// POST /devices/{id}/tags adds tags to a device (or changes their values) and DELETE /devices/{id}/tags/{key}
+// removes one, other fields and tags of the device stay as they are.
+func (ig *dynamoDBAPI) DeviceTags(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:write scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+
+	id := request.PathParameters["id"]
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "No ID Field Provided"),
+			StatusCode: 404,
+		}, nil
+	}
+
+	switch request.HTTPMethod {
+	case "POST":
+		return ig.addTags(ctx, principal, id, request)
+	case "DELETE":
+		return ig.removeTag(ctx, principal, id, request)
+	}
+
+	return events.APIGatewayProxyResponse{
+		Body:	createErrorResponseJson(405, "Method not allowed"),
+		StatusCode: 405,
+	}, nil
+}
+
+func (ig *dynamoDBAPI) addTags(ctx context.Context, principal *auth.Principal, id string, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	_, span := tracing.Start(ctx, "validate request")
+	tags, reason, err := validateInputs(request)
+	span.SetAttribute("validation.reason", reason)
+	span.Finish()
+	if err != nil {
+		metrics.ValidationFailure(ctx, reason)
+		return events.APIGatewayProxyResponse{
+			Body:	err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// caller's roles must allow changing tags, like PATCH /devices/{id} with tags
+	if denied := policy.Check(principal, policy.UpdatePermissions([]string{"tags"})...); denied != nil {
+		return *denied, nil
+	}
+
+	device, err := ig.changeTags(ctx, principal.TenantID, id, func(current map[string]string) (map[string]string, error) {
+		changed := map[string]string{}
+		for key, value := range current {
+			changed[key] = value
+		}
+		for key, value := range tags {
+			changed[key] = value
+		}
+		if len(changed) > types.MAX_TAGS {
+			return nil, ErrTooManyTags
+		}
+		return changed, nil
+	})
+	if err == ErrTooManyTags {
+		metrics.ValidationFailure(ctx, REASON_TOO_MANY_TAGS)
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(400, err.Error()),
+			StatusCode: 400,
+		}, nil
+	}
+	return ig.deviceResponse(ctx, principal, id, device, "tags added", err)
+}
+
+func (ig *dynamoDBAPI) removeTag(ctx context.Context, principal *auth.Principal, id string, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	if denied := policy.Check(principal, policy.UpdatePermissions([]string{"tags"})...); denied != nil {
+		return *denied, nil
+	}
+
+	// removing a tag that the device doesn't have changes nothing, so retried requests succeed too
+	key := request.PathParameters["key"]
+	device, err := ig.changeTags(ctx, principal.TenantID, id, func(current map[string]string) (map[string]string, error) {
+		changed := map[string]string{}
+		for name, value := range current {
+			if name != key {
+				changed[name] = value
+			}
+		}
+		return changed, nil
+	})
+	return ig.deviceResponse(ctx, principal, id, device, "tag removed", err)
+}
+
+// deviceResponse maps result of changeTags to a response, unexpected errors are mapped by apigw.ErrorMapping
+func (ig *dynamoDBAPI) deviceResponse(ctx context.Context, principal *auth.Principal, id string, device types.Device, status string, err error) (events.APIGatewayProxyResponse, error) {
+	if err == ErrDeviceNotFound {
+		devices.LogCrossTenantAccess(ctx, ig.DynamoDB, ig.TableName, principal, id)
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Desired device with provided id was not founded"),
+			StatusCode: 404,
+		}, nil
+	}
+	if err == ErrDeviceRetired {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, "Retired devices can't be changed"),
+			StatusCode: 409,
+		}, nil
+	}
+	if err == ErrTooManyConflicts {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, "Tags of the device are changed concurrently, please retry"),
+			StatusCode: 409,
+		}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	successResponseJson, _ := json.MarshalIndent(&SuccessResponse{Status: status, Device: device}, "", "\t")
+	return events.APIGatewayProxyResponse{
+		Body:	string(successResponseJson),
+		StatusCode: 200,
+	}, nil
+}
+
+// validateInputs returns tags of the body, or reason and error body of rejecting them.
+// body is an object of tags like {"site": "berlin"}, validated against tags of the device schema.
+func validateInputs(request events.APIGatewayProxyRequest) (map[string]string, string, error) {
+
+	if len(request.Body) == 0 {
+		return nil, REASON_EMPTY_BODY, errors.New(createErrorResponseJson(400, "No inputs provided, please provide inputs in json format."))
+	}
+
+	var body interface{}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return nil, REASON_INVALID_JSON, errors.New(createErrorResponseJson(400, "Wrong format: Inputs must be a valid json."))
+	}
+
+	if violations := api.ValidateTags(body); len(violations) != 0 {
+		errorMessage := "Tags don't match their schema " + api.SCHEMAS_PATH + "device.json"
+		return nil, REASON_SCHEMA_VIOLATION, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
+	}
+
+	tags := map[string]string{}
+	for key, value := range body.(map[string]interface{}) {
+		tags[key] = value.(string)
+	}
+	return tags, "", nil
+}
+
+func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
+	return types.NewErrorResponseJson(errorCode, errorMessage)
+}
+
+// changeTags reads tags of a device, changes them with change and writes them back only if nobody changed them
+// in between, otherwise it starts over. It returns the updated device, retired devices are never changed.
+func (ig *dynamoDBAPI) changeTags(ctx context.Context, tenantId string, id string, change func(map[string]string) (map[string]string, error)) (types.Device, error) {
+	for attempt := 0; attempt < MAX_CONFLICT_RETRIES; attempt++ {
+		current, err := ig.getItemFromDatabase(ctx, tenantId, id)
+		if err != nil {
+			return types.Device{}, err
+		}
+		if current.CurrentStatus() == types.STATUS_RETIRED {
+			return types.Device{}, ErrDeviceRetired
+		}
+
+		tags, err := change(current.Tags)
+		if err != nil {
+			return types.Device{}, err
+		}
+		if reflect.DeepEqual(tags, current.Tags) || (len(tags) == 0 && len(current.Tags) == 0) {
+			return current, nil
+		}
+
+		device, err := ig.updateItemInDatabase(ctx, tenantId, id, current.Tags, tags)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			continue
+		}
+		return device, err
+	}
+	return types.Device{}, ErrTooManyConflicts
+}
+
+// function that returns a device of the tenant, ErrDeviceNotFound when it doesn't exist
+func (ig *dynamoDBAPI) getItemFromDatabase(ctx context.Context, tenantId string, id string) (types.Device, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: ig.TableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	var output *dynamodb.GetItemOutput
+	err := ig.Retry.Do(ctx, func() (err error) {
+		output, err = ig.DynamoDB.GetItemWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return types.Device{}, err
+	}
+	if len(output.Item) == 0 {
+		return types.Device{}, ErrDeviceNotFound
+	}
+
+	device := types.Device{}
+	err = dynamodbattribute.UnmarshalMap(output.Item, &device)
+	return device, err
+}
+
+// function that replaces tags of a device with tags, only if its tags are still current and it isn't retired meanwhile.
+// tags are stored as a native map (M) so listings can filter by them, a device without tags doesn't have the attribute.
+func (ig *dynamoDBAPI) updateItemInDatabase(ctx context.Context, tenantId string, id string, current map[string]string, tags map[string]string) (types.Device, error) {
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: ig.TableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ExpressionAttributeNames: map[string]*string{"#tags": aws.String("tags"), "#status": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{":retired": {S: aws.String(types.STATUS_RETIRED)}},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	}
+
+	if len(tags) == 0 {
+		input.UpdateExpression = aws.String("REMOVE #tags")
+	} else {
+		value, err := dynamodbattribute.Marshal(tags)
+		if err != nil {
+			return types.Device{}, err
+		}
+		input.UpdateExpression = aws.String("SET #tags = :tags")
+		input.ExpressionAttributeValues[":tags"] = value
+	}
+
+	notRetired := " AND (attribute_not_exists(#status) OR #status <> :retired)"
+	if len(current) == 0 {
+		input.ConditionExpression = aws.String("attribute_exists(id) AND attribute_not_exists(#tags)" + notRetired)
+	} else {
+		value, err := dynamodbattribute.Marshal(current)
+		if err != nil {
+			return types.Device{}, err
+		}
+		input.ConditionExpression = aws.String("#tags = :current" + notRetired)
+		input.ExpressionAttributeValues[":current"] = value
+	}
+
+	var output *dynamodb.UpdateItemOutput
+	err := ig.Retry.Do(ctx, func() (err error) {
+		output, err = ig.DynamoDB.UpdateItemWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return types.Device{}, err
+	}
+
+	device := types.Device{}
+	err = dynamodbattribute.UnmarshalMap(output.Attributes, &device)
+	return device, err
+}
+
+// newHandler wraps DeviceTags with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services.
+func newHandler(services *apigw.Services) apigw.Handler {
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry}
+	return apigw.Chain(table.DeviceTags, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("deviceTags")...)
+}
@@ -0,0 +1,252 @@
+package main
+
+import(
+	"api"
+	"apigwtest"
+	"auth"
+	"types"
+	"testing"
+	"context"
+	"reflect"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	StoredTags 					map[string]string
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+	ExpectedUpdates 			int
+}
+
+// A fakeDynamoDB instance for mocking test that emulates real DynamoDB.
+// it stores "id_test" of "tenant_test" with Tags, "id_conflict" is changed by someone else before every update.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	Tags	map[string]string
+	Updates	int
+}
+
+// a mocked version of DynamoDB's Query function on the id index, it's used for logging cross-tenant access
+// attempts and devices of other tenants aren't known
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{}, nil
+}
+
+func (fd *FakeDynamoDBAPI) item(id string) map[string]*dynamodb.AttributeValue {
+	item := map[string]*dynamodb.AttributeValue{
+		"id": &dynamodb.AttributeValue{S: aws.String(id)},
+		"tenantId": &dynamodb.AttributeValue{S: aws.String("tenant_test")},
+		"deviceModel": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+		"name": &dynamodb.AttributeValue{S: aws.String("name_test")},
+		"note": &dynamodb.AttributeValue{S: aws.String("note_test")},
+		"serial": &dynamodb.AttributeValue{S: aws.String("serial_test")},
+	}
+	if len(fd.Tags) != 0 {
+		item["tags"], _ = dynamodbattribute.Marshal(fd.Tags)
+	}
+	return item
+}
+
+// a mocked version of DynamoDB's GetItem function
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	output := new(dynamodb.GetItemOutput)
+	id := *input.Key["id"].S
+	if id == "id_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	if *input.Key["tenantId"].S == "tenant_test" && (id == "id_test" || id == "id_conflict") {
+		output.SetItem(fd.item(id))
+	}
+	if *input.Key["tenantId"].S == "tenant_test" && id == "id_retired" {
+		item := fd.item(id)
+		item["status"] = &dynamodb.AttributeValue{S: aws.String(types.STATUS_RETIRED)}
+		output.SetItem(item)
+	}
+	return output, nil
+}
+
+// a mocked version of DynamoDB's UpdateItem function, it checks that stored tags are still the current ones
+func (fd *FakeDynamoDBAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	fd.Updates++
+	id := *input.Key["id"].S
+	conditionFailed := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	if id == "id_conflict" {
+		return nil, conditionFailed
+	}
+
+	current := map[string]string{}
+	if value, ok := input.ExpressionAttributeValues[":current"]; ok {
+		dynamodbattribute.Unmarshal(value, &current)
+	}
+	if !reflect.DeepEqual(current, fd.Tags) && !(len(current) == 0 && len(fd.Tags) == 0) {
+		return nil, conditionFailed
+	}
+
+	fd.Tags = nil
+	if value, ok := input.ExpressionAttributeValues[":tags"]; ok {
+		dynamodbattribute.Unmarshal(value, &fd.Tags)
+	}
+	return &dynamodb.UpdateItemOutput{Attributes: fd.item(id)}, nil
+}
+
+// a viewer key that only has devices:read scope, keys of apigwtest have devices:write too
+const READ_API_KEY = "readkey." + apigwtest.SECRET
+
+var testKeys = &apigwtest.FakeKeysDynamoDBAPI{Keys: []auth.ApiKey{{ID: "readkey", Scopes: []string{auth.SCOPE_DEVICES_READ}, Roles: []string{"viewer"}, TenantID: apigwtest.TENANT}}}
+
+func TestDeviceTags(t *testing.T) {
+
+	tooManyTags := map[string]string{}
+	for _, key := range "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWX" {
+		tooManyTags[string(key)] = "1"
+	}
+
+	post := func(id string, body string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/devices/{id}/tags", PathParameters: map[string]string{"id": id}, Body: body}
+	}
+	remove := func(id string, key string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{HTTPMethod: "DELETE", Resource: "/devices/{id}/tags/{key}", PathParameters: map[string]string{"id": id, "key": key}}
+	}
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing api key without devices:write scope **",
+			InputRequest:		events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/devices/{id}/tags", Headers: map[string]string{"X-Api-Key": READ_API_KEY}, PathParameters: map[string]string{"id": "id_test"}, Body: "{\"site\": \"berlin\"}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 403,\n\t\t\"message\": \"API key lacks required scope: devices:write\"\n\t}\n}",
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name:				"** Testing adding tags to a device without tags **",
+			InputRequest:		post("id_test", "{\"site\": \"berlin\", \"env\": \"prod\"}"),
+			ExpectedBody:		"{\n\t\"status\": \"tags added\",\n\t\"data\": {\n\t\t\"id\": \"id_test\",\n\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\"name\": \"name_test\",\n\t\t\"note\": \"note_test\",\n\t\t\"serial\": \"serial_test\",\n\t\t\"tags\": {\n\t\t\t\"env\": \"prod\",\n\t\t\t\"site\": \"berlin\"\n\t\t}\n\t}\n}",
+			ExpectedStatusCode:	200,
+			ExpectedUpdates:	1,
+		},
+		{
+			Name:				"** Testing operator changes a tag and keeps others **",
+			InputRequest:		events.APIGatewayProxyRequest{HTTPMethod: "POST", Resource: "/devices/{id}/tags", Headers: map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}, PathParameters: map[string]string{"id": "id_test"}, Body: "{\"site\": \"paris\"}"},
+			StoredTags:			map[string]string{"site": "berlin", "env": "prod"},
+			ExpectedBody:		"{\n\t\"status\": \"tags added\",\n\t\"data\": {\n\t\t\"id\": \"id_test\",\n\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\"name\": \"name_test\",\n\t\t\"note\": \"note_test\",\n\t\t\"serial\": \"serial_test\",\n\t\t\"tags\": {\n\t\t\t\"env\": \"prod\",\n\t\t\t\"site\": \"paris\"\n\t\t}\n\t}\n}",
+			ExpectedStatusCode:	200,
+			ExpectedUpdates:	1,
+		},
+		{
+			Name:				"** Testing invalid tag key and empty value **",
+			InputRequest:		post("id_test", "{\"site name\": \"berlin\", \"env\": \"\"}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Tags don't match their schema /schemas/device.json\",\n\t\t\"violations\": [\n\t\t\t{\n\t\t\t\t\"pointer\": \"/env\",\n\t\t\t\t\"message\": \"must not be empty\"\n\t\t\t},\n\t\t\t{\n\t\t\t\t\"pointer\": \"/site name\",\n\t\t\t\t\"message\": \"name must match pattern ^[A-Za-z0-9_.+/@-]+$\"\n\t\t\t}\n\t\t]\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing empty tags object **",
+			InputRequest:		post("id_test", "{}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Tags don't match their schema /schemas/device.json\",\n\t\t\"violations\": [\n\t\t\t{\n\t\t\t\t\"pointer\": \"\",\n\t\t\t\t\"message\": \"must not be empty\"\n\t\t\t}\n\t\t]\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing empty body **",
+			InputRequest:		post("id_test", ""),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"No inputs provided, please provide inputs in json format.\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing too many tags after merging **",
+			InputRequest:		post("id_test", "{\"site\": \"berlin\"}"),
+			StoredTags:			tooManyTags,
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"A device can have at most 50 tags\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing adding tags to a missing device **",
+			InputRequest:		post("id_missing", "{\"site\": \"berlin\"}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Desired device with provided id was not founded\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing adding tags to a retired device **",
+			InputRequest:		post("id_retired", "{\"site\": \"berlin\"}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 409,\n\t\t\"message\": \"Retired devices can't be changed\"\n\t}\n}",
+			ExpectedStatusCode:	409,
+		},
+		{
+			Name:				"** Testing concurrent changes of tags **",
+			InputRequest:		post("id_conflict", "{\"site\": \"berlin\"}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 409,\n\t\t\"message\": \"Tags of the device are changed concurrently, please retry\"\n\t}\n}",
+			ExpectedStatusCode:	409,
+			ExpectedUpdates:	MAX_CONFLICT_RETRIES,
+		},
+		{
+			Name:				"** Testing removing a tag **",
+			InputRequest:		remove("id_test", "env"),
+			StoredTags:			map[string]string{"site": "berlin", "env": "prod"},
+			ExpectedBody:		"{\n\t\"status\": \"tag removed\",\n\t\"data\": {\n\t\t\"id\": \"id_test\",\n\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\"name\": \"name_test\",\n\t\t\"note\": \"note_test\",\n\t\t\"serial\": \"serial_test\",\n\t\t\"tags\": {\n\t\t\t\"site\": \"berlin\"\n\t\t}\n\t}\n}",
+			ExpectedStatusCode:	200,
+			ExpectedUpdates:	1,
+		},
+		{
+			Name:				"** Testing removing the last tag **",
+			InputRequest:		remove("id_test", "site"),
+			StoredTags:			map[string]string{"site": "berlin"},
+			ExpectedBody:		"{\n\t\"status\": \"tag removed\",\n\t\"data\": {\n\t\t\"id\": \"id_test\",\n\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\"name\": \"name_test\",\n\t\t\"note\": \"note_test\",\n\t\t\"serial\": \"serial_test\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+			ExpectedUpdates:	1,
+		},
+		{
+			Name:				"** Testing removing a missing tag changes nothing **",
+			InputRequest:		remove("id_test", "floor"),
+			StoredTags:			map[string]string{"site": "berlin"},
+			ExpectedBody:		"{\n\t\"status\": \"tag removed\",\n\t\"data\": {\n\t\t\"id\": \"id_test\",\n\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\"name\": \"name_test\",\n\t\t\"note\": \"note_test\",\n\t\t\"serial\": \"serial_test\",\n\t\t\"tags\": {\n\t\t\t\"site\": \"berlin\"\n\t\t}\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing removing a tag of a missing device **",
+			InputRequest:		remove("id_missing", "site"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Desired device with provided id was not founded\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		remove("id_error", "site"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}",
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases.
+		devices := &FakeDynamoDBAPI{Tags: test.StoredTags}
+		handler := newHandler(apigwtest.NewServices(devices, testKeys))
+
+		// requests without explicit headers are sent with a valid admin key
+		if test.InputRequest.Headers == nil {
+			test.InputRequest.Headers = map[string]string{"X-Api-Key": apigwtest.ADMIN_API_KEY}
+		}
+
+		// calls deviceTags.go's DeviceTags function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse(test.InputRequest.HTTPMethod, test.InputRequest.Resource, response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+
+		if devices.Updates != test.ExpectedUpdates {
+			t.Errorf("%s \n \t<expected updates: %d> <resulted updates: %d>", test.Name, test.ExpectedUpdates, devices.Updates)
+		}
+	}
+
+} // end of TestDeviceTags function
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"devices"
+	"policy"
+	"localserver"
+	"metrics"
+	"retry"
+	"tracing"
+	"types"
+	"fmt"
+	"time"
+	"context"
+	"strings"
+	"strconv"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// custom method suffix of the device id, API Gateway can't route on a part of a path segment
+const TRANSITION_SUFFIX = ":transition"
+
+// how many times status is read again when the device is changed concurrently
+const MAX_CONFLICT_RETRIES = 3
+
+// how many transitions a device keeps, older ones are dropped so the item doesn't outgrow DynamoDB's item size
+const MAX_TRANSITIONS = 100
+
+var ErrDeviceNotFound = errors.New("device not found")
+var ErrTooManyConflicts = errors.New("device is changed concurrently too many times")
+
+// ErrIllegalTransition is returned when the device's status can't move to the requested one
+type ErrIllegalTransition struct {
+	From	string
+	To		string
+}
+
+func (e *ErrIllegalTransition) Error() string {
+	allowed := strings.Join(types.StatusTransitions[e.From], ", ")
+	if len(allowed) == 0 {
+		allowed = "none"
+	}
+	return fmt.Sprintf("Device can't transition from %s to %s, allowed transitions from %s: %s", e.From, e.To, e.From, allowed)
+}
+
+// reasons of rejected inputs, they are Reason dimension of ValidationFailures metric
+const REASON_EMPTY_BODY = "empty_body"
+const REASON_INVALID_JSON = "invalid_json"
+const REASON_SCHEMA_VIOLATION = "schema_violation"
+
+type SuccessResponse = types.TransitionResponse
+
+// devices table of the handler, it's built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Now func() time.Time
+}
+
+// main AWS lambda function starting point.
+// It moves a device to another status of its lifecycle (see types.StatusTransitions) and records who did it and why.
+// Illegal transitions get 409, so do retired devices which can't move anymore.
+func (ig *dynamoDBAPI) DeviceTransition(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:write scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+
+	// POST /devices/{id} is only routed for the transition custom method
+	id := request.PathParameters["id"]
+	if !strings.HasSuffix(id, TRANSITION_SUFFIX) {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Unknown operation, statuses are changed by POST /devices/{id}" + TRANSITION_SUFFIX),
+			StatusCode: 404,
+		}, nil
+	}
+	id = strings.TrimSuffix(id, TRANSITION_SUFFIX)
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "No ID Field Provided"),
+			StatusCode: 404,
+		}, nil
+	}
+
+	// caller's roles must allow changing statuses, unauthorized callers get 403 whatever they send
+	if denied := policy.Check(principal, policy.PERMISSION_DEVICES_TRANSITION); denied != nil {
+		return *denied, nil
+	}
+
+	_, span := tracing.Start(ctx, "validate request")
+	transitionRequest, reason, err := validateInputs(request)
+	span.SetAttribute("validation.reason", reason)
+	span.Finish()
+	if err != nil {
+		metrics.ValidationFailure(ctx, reason)
+		return events.APIGatewayProxyResponse{
+			Body:	err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	transition := types.StatusTransition{
+		To:		transitionRequest.To,
+		Reason:	transitionRequest.Reason,
+		By:		principal.ID,
+		At:		ig.Now().UTC().Format(time.RFC3339),
+	}
+	device, transition, err := ig.transition(ctx, principal.TenantID, id, transition)
+	if err == ErrDeviceNotFound {
+		devices.LogCrossTenantAccess(ctx, ig.DynamoDB, ig.TableName, principal, id)
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Desired device with provided id was not founded"),
+			StatusCode: 404,
+		}, nil
+	}
+	if illegal, ok := err.(*ErrIllegalTransition); ok {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, illegal.Error()),
+			StatusCode: 409,
+		}, nil
+	}
+	if err == ErrTooManyConflicts {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, "Status of the device is changed concurrently, please retry"),
+			StatusCode: 409,
+		}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	fmt.Printf("Device transition: principal %s of tenant %s moved device %s from %s to %s: %s\n", principal.ID, principal.TenantID, id, transition.From, transition.To, transition.Reason)
+
+	successResponseJson, _ := json.MarshalIndent(&SuccessResponse{Status: "status changed", Device: device, Transition: transition}, "", "\t")
+	return events.APIGatewayProxyResponse{
+		Body:	string(successResponseJson),
+		StatusCode: 200,
+	}, nil
+}
+
+// validateInputs returns the requested transition, or reason and error body of rejecting it.
+// body is validated against the transition schema (GET /schemas/transition.json).
+func validateInputs(request events.APIGatewayProxyRequest) (types.TransitionRequest, string, error) {
+
+	if len(request.Body) == 0 {
+		return types.TransitionRequest{}, REASON_EMPTY_BODY, errors.New(createErrorResponseJson(400, "No inputs provided, please provide inputs in json format."))
+	}
+
+	var body interface{}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return types.TransitionRequest{}, REASON_INVALID_JSON, errors.New(createErrorResponseJson(400, "Wrong format: Inputs must be a valid json."))
+	}
+
+	if violations := api.ValidateTransition(body); len(violations) != 0 {
+		errorMessage := "Transition doesn't match its schema " + api.SCHEMAS_PATH + "transition.json"
+		return types.TransitionRequest{}, REASON_SCHEMA_VIOLATION, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
+	}
+
+	transitionRequest := types.TransitionRequest{}
+	json.Unmarshal([]byte(request.Body), &transitionRequest)
+	return transitionRequest, "", nil
+}
+
+func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
+	return types.NewErrorResponseJson(errorCode, errorMessage)
+}
+
+// transition reads status of a device, checks that it can move to transition.To and writes the new status only if
+// nobody changed it in between, otherwise it starts over. It returns the updated device and the recorded transition.
+func (ig *dynamoDBAPI) transition(ctx context.Context, tenantId string, id string, transition types.StatusTransition) (types.Device, types.StatusTransition, error) {
+	for attempt := 0; attempt < MAX_CONFLICT_RETRIES; attempt++ {
+		current, recorded, err := ig.getItemFromDatabase(ctx, tenantId, id)
+		if err != nil {
+			return types.Device{}, transition, err
+		}
+
+		transition.From = current.CurrentStatus()
+		if !types.CanTransition(transition.From, transition.To) {
+			return types.Device{}, transition, &ErrIllegalTransition{From: transition.From, To: transition.To}
+		}
+
+		device, err := ig.updateItemInDatabase(ctx, tenantId, id, transition, recorded)
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			continue
+		}
+		return device, transition, err
+	}
+	return types.Device{}, transition, ErrTooManyConflicts
+}
+
+// function that returns a device of the tenant with its recorded transitions, ErrDeviceNotFound when it doesn't exist
+func (ig *dynamoDBAPI) getItemFromDatabase(ctx context.Context, tenantId string, id string) (types.Device, []types.StatusTransition, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: ig.TableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	var output *dynamodb.GetItemOutput
+	err := ig.Retry.Do(ctx, func() (err error) {
+		output, err = ig.DynamoDB.GetItemWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return types.Device{}, nil, err
+	}
+	if len(output.Item) == 0 {
+		return types.Device{}, nil, ErrDeviceNotFound
+	}
+
+	device := types.Device{}
+	if err = dynamodbattribute.UnmarshalMap(output.Item, &device); err != nil {
+		return types.Device{}, nil, err
+	}
+	recorded := []types.StatusTransition{}
+	if output.Item["transitions"] != nil {
+		err = dynamodbattribute.Unmarshal(output.Item["transitions"], &recorded)
+	}
+	return device, recorded, err
+}
+
+// function that sets status of a device to transition.To, only if its status is still transition.From and its transitions
+// are still recorded. transition is appended to recorded and only the last MAX_TRANSITIONS are written back to the transitions
+// attribute of the device, which isn't a field of types.Device.
+func (ig *dynamoDBAPI) updateItemInDatabase(ctx context.Context, tenantId string, id string, transition types.StatusTransition, recorded []types.StatusTransition) (types.Device, error) {
+
+	kept := append(recorded, transition)
+	if len(kept) > MAX_TRANSITIONS {
+		kept = kept[len(kept) - MAX_TRANSITIONS:]
+	}
+	transitions, err := dynamodbattribute.Marshal(kept)
+	if err != nil {
+		return types.Device{}, err
+	}
+
+	// the whole list is replaced, so it must not have changed since it's read
+	condition := "#status = :from"
+	if transition.From == types.STATUS_PROVISIONED {
+		// devices stored before statuses existed don't have the attribute, they are provisioned
+		condition = "attribute_exists(id) AND (#status = :from OR attribute_not_exists(#status))"
+	}
+	if len(recorded) == 0 {
+		condition += " AND attribute_not_exists(#transitions)"
+	} else {
+		condition += " AND size(#transitions) = :recorded"
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: ig.TableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		UpdateExpression: aws.String("SET #status = :to, #transitions = :transitions"),
+		ConditionExpression: aws.String(condition),
+		ExpressionAttributeNames: map[string]*string{
+			"#status": aws.String("status"),
+			"#transitions": aws.String("transitions"),
+		},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":from": {S: aws.String(transition.From)},
+			":to": {S: aws.String(transition.To)},
+			":transitions": transitions,
+		},
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	}
+	if len(recorded) != 0 {
+		input.ExpressionAttributeValues[":recorded"] = &dynamodb.AttributeValue{N: aws.String(strconv.Itoa(len(recorded)))}
+	}
+
+	var output *dynamodb.UpdateItemOutput
+	err = ig.Retry.Do(ctx, func() (err error) {
+		output, err = ig.DynamoDB.UpdateItemWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return types.Device{}, err
+	}
+
+	device := types.Device{}
+	err = dynamodbattribute.UnmarshalMap(output.Attributes, &device)
+	return device, err
+}
+
+// newHandler wraps DeviceTransition with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services.
+func newHandler(services *apigw.Services) apigw.Handler {
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Now: time.Now}
+	return apigw.Chain(table.DeviceTransition, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("deviceTransition")...)
+}
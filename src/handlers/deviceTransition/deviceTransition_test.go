@@ -0,0 +1,258 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"retry"
+	"schema"
+	"types"
+	"testing"
+	"strconv"
+	"context"
+	"time"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	StoredStatus 				string
+	StoredTransitions 			int
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+	ExpectedTransitions 		int
+}
+
+// A fakeDynamoDB instance for mocking test that emulates real DynamoDB.
+// it stores "id_test" of "tenant_test" with Status (none when it's empty), "id_conflict" is changed by someone
+// else before every update.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	Status		string
+	Transitions	[]types.StatusTransition
+}
+
+// a mocked version of DynamoDB's Query function on the id index, it's used for logging cross-tenant access
+// attempts and devices of other tenants aren't known
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{}, nil
+}
+
+func (fd *FakeDynamoDBAPI) item(id string) map[string]*dynamodb.AttributeValue {
+	item := map[string]*dynamodb.AttributeValue{
+		"id": &dynamodb.AttributeValue{S: aws.String(id)},
+		"tenantId": &dynamodb.AttributeValue{S: aws.String("tenant_test")},
+		"deviceModel": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+		"name": &dynamodb.AttributeValue{S: aws.String("name_test")},
+		"note": &dynamodb.AttributeValue{S: aws.String("note_test")},
+		"serial": &dynamodb.AttributeValue{S: aws.String("serial_test")},
+	}
+	if len(fd.Status) != 0 {
+		item["status"] = &dynamodb.AttributeValue{S: aws.String(fd.Status)}
+	}
+	if len(fd.Transitions) != 0 {
+		item["transitions"], _ = dynamodbattribute.Marshal(fd.Transitions)
+	}
+	return item
+}
+
+// a mocked version of DynamoDB's GetItem function
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	output := new(dynamodb.GetItemOutput)
+	id := *input.Key["id"].S
+	if id == "id_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	if *input.Key["tenantId"].S == "tenant_test" && (id == "id_test" || id == "id_conflict") {
+		output.SetItem(fd.item(id))
+	}
+	return output, nil
+}
+
+// a mocked version of DynamoDB's UpdateItem function, it checks that the stored status and count of transitions are still the current ones
+func (fd *FakeDynamoDBAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	id := *input.Key["id"].S
+	stored := fd.Status
+	if len(stored) == 0 {
+		stored = types.STATUS_PROVISIONED
+	}
+	recorded := "0"
+	if value, ok := input.ExpressionAttributeValues[":recorded"]; ok {
+		recorded = *value.N
+	}
+	if id == "id_conflict" || *input.ExpressionAttributeValues[":from"].S != stored || recorded != strconv.Itoa(len(fd.Transitions)) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+
+	fd.Transitions = []types.StatusTransition{}
+	dynamodbattribute.Unmarshal(input.ExpressionAttributeValues[":transitions"], &fd.Transitions)
+	fd.Status = *input.ExpressionAttributeValues[":to"].S
+	return &dynamodb.UpdateItemOutput{Attributes: fd.item(id)}, nil
+}
+
+func TestDeviceTransition(t *testing.T) {
+
+	transition := func(id string, body string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": id}, Body: body}
+	}
+	device := func(status string) string {
+		return "{\n\t\t\"id\": \"id_test\",\n\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\"name\": \"name_test\",\n\t\t\"note\": \"note_test\",\n\t\t\"serial\": \"serial_test\",\n\t\t\"status\": \"" + status + "\"\n\t}"
+	}
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing viewer can't change statuses **",
+			InputRequest:		events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.VIEWER_API_KEY}, PathParameters: map[string]string{"id": "id_test:transition"}, Body: "{\"to\": \"active\", \"reason\": \"installed\"}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 403,\n\t\t\"message\": \"Operation is not permitted: none of roles [viewer] grants devices:transition\"\n\t}\n}",
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name:				"** Testing viewer gets 403 before the body is validated **",
+			InputRequest:		events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.VIEWER_API_KEY}, PathParameters: map[string]string{"id": "id_test:transition"}, Body: "{\"to\": \"broken\"}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 403,\n\t\t\"message\": \"Operation is not permitted: none of roles [viewer] grants devices:transition\"\n\t}\n}",
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name:				"** Testing device stored without status is activated **",
+			InputRequest:		transition("id_test:transition", "{\"to\": \"active\", \"reason\": \"installed on site\"}"),
+			ExpectedBody:		"{\n\t\"status\": \"status changed\",\n\t\"data\": " + device("active") + ",\n\t\"transition\": {\n\t\t\"from\": \"provisioned\",\n\t\t\"to\": \"active\",\n\t\t\"reason\": \"installed on site\",\n\t\t\"by\": \"operatorkey\",\n\t\t\"at\": \"2018-06-26T08:00:00Z\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+			ExpectedTransitions:	1,
+		},
+		{
+			Name:				"** Testing active device goes to maintenance **",
+			InputRequest:		transition("id_test:transition", "{\"to\": \"maintenance\", \"reason\": \"battery replacement\"}"),
+			StoredStatus:		types.STATUS_ACTIVE,
+			ExpectedBody:		"{\n\t\"status\": \"status changed\",\n\t\"data\": " + device("maintenance") + ",\n\t\"transition\": {\n\t\t\"from\": \"active\",\n\t\t\"to\": \"maintenance\",\n\t\t\"reason\": \"battery replacement\",\n\t\t\"by\": \"operatorkey\",\n\t\t\"at\": \"2018-06-26T08:00:00Z\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+			ExpectedTransitions:	1,
+		},
+		{
+			Name:				"** Testing oldest transitions are dropped **",
+			InputRequest:		transition("id_test:transition", "{\"to\": \"maintenance\", \"reason\": \"battery replacement\"}"),
+			StoredStatus:		types.STATUS_ACTIVE,
+			StoredTransitions:	MAX_TRANSITIONS,
+			ExpectedBody:		"{\n\t\"status\": \"status changed\",\n\t\"data\": " + device("maintenance") + ",\n\t\"transition\": {\n\t\t\"from\": \"active\",\n\t\t\"to\": \"maintenance\",\n\t\t\"reason\": \"battery replacement\",\n\t\t\"by\": \"operatorkey\",\n\t\t\"at\": \"2018-06-26T08:00:00Z\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+			ExpectedTransitions:	MAX_TRANSITIONS,
+		},
+		{
+			Name:				"** Testing illegal transition **",
+			InputRequest:		transition("id_test:transition", "{\"to\": \"maintenance\", \"reason\": \"battery replacement\"}"),
+			StoredStatus:		types.STATUS_PROVISIONED,
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 409,\n\t\t\"message\": \"Device can't transition from provisioned to maintenance, allowed transitions from provisioned: active, retired\"\n\t}\n}",
+			ExpectedStatusCode:	409,
+		},
+		{
+			Name:				"** Testing retired device can't transition **",
+			InputRequest:		transition("id_test:transition", "{\"to\": \"active\", \"reason\": \"reused\"}"),
+			StoredStatus:		types.STATUS_RETIRED,
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 409,\n\t\t\"message\": \"Device can't transition from retired to active, allowed transitions from retired: none\"\n\t}\n}",
+			ExpectedStatusCode:	409,
+		},
+		{
+			Name:				"** Testing invalid status and missing reason **",
+			InputRequest:		transition("id_test:transition", "{\"to\": \"broken\"}"),
+			ExpectedBody:		types.NewViolationsResponseJson(400, "Transition doesn't match its schema /schemas/transition.json", []schema.Violation{
+				{Pointer: "/reason", Message: "is required"},
+				{Pointer: "/to", Message: "must be one of [\"provisioned\",\"active\",\"maintenance\",\"retired\"]"},
+			}),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing path without the custom method **",
+			InputRequest:		transition("id_test", "{\"to\": \"active\", \"reason\": \"installed\"}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Unknown operation, statuses are changed by POST /devices/{id}:transition\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing missing device **",
+			InputRequest:		transition("id_missing:transition", "{\"to\": \"active\", \"reason\": \"installed\"}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Desired device with provided id was not founded\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing concurrent changes of status **",
+			InputRequest:		transition("id_conflict:transition", "{\"to\": \"active\", \"reason\": \"installed\"}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 409,\n\t\t\"message\": \"Status of the device is changed concurrently, please retry\"\n\t}\n}",
+			ExpectedStatusCode:	409,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		transition("id_error:transition", "{\"to\": \"active\", \"reason\": \"installed\"}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}",
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases, transitions are recorded at a fixed time
+		devices := &FakeDynamoDBAPI{Status: test.StoredStatus}
+		for i := 0; i < test.StoredTransitions; i++ {
+			devices.Transitions = append(devices.Transitions, types.StatusTransition{From: types.STATUS_MAINTENANCE, To: types.STATUS_ACTIVE, Reason: "stored " + strconv.Itoa(i)})
+		}
+		stored := devices.Transitions
+		services := apigwtest.NewServices(devices, nil)
+		transitions := &dynamoDBAPI{DynamoDB: devices, TableName: aws.String("test_table_name"), Retry: retry.Default, Now: func() time.Time { return time.Unix(1530000000, 0) }}
+		handler := apigw.Chain(transitions.DeviceTransition, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+
+		// requests without explicit headers are sent with an operator key
+		if test.InputRequest.Headers == nil {
+			test.InputRequest.Headers = map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}
+		}
+
+		// calls deviceTransition.go's DeviceTransition function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("POST", "/devices/{id}", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+
+		if len(devices.Transitions) != test.ExpectedTransitions {
+			t.Errorf("%s \n \t<expected recorded transitions: %d> <resulted recorded transitions: %v>", test.Name, test.ExpectedTransitions, devices.Transitions)
+		}
+
+		// the new transition is the last one and older ones are dropped first
+		if test.ExpectedStatusCode == 200 && len(devices.Transitions) != 0 {
+			last := devices.Transitions[len(devices.Transitions) - 1]
+			dropped := len(stored) + 1 - len(devices.Transitions)
+			if last.At != "2018-06-26T08:00:00Z" || len(stored) != 0 && devices.Transitions[0] != stored[dropped] {
+				t.Errorf("%s \n \t<resulted recorded transitions: %v>", test.Name, devices.Transitions)
+			}
+		}
+	}
+
+} // end of TestDeviceTransition function
+
+func TestStatusTransitions(t *testing.T) {
+
+	// every status of the transition table is allowed by the schemas, and every target is a known status
+	statuses := api.DeviceSchema()["properties"].(schema.Schema)["status"].(schema.Schema)["enum"].([]interface{})
+	targets := api.TransitionSchema()["properties"].(schema.Schema)["to"].(schema.Schema)["enum"].([]interface{})
+	if len(statuses) != len(types.StatusTransitions) || len(targets) != len(types.StatusTransitions) {
+		t.Errorf("** Testing statuses of schemas ** \n \t<expected statuses: %v> <resulted statuses: %v %v>", types.StatusTransitions, statuses, targets)
+	}
+	for from, allowed := range types.StatusTransitions {
+		for _, to := range allowed {
+			if _, ok := types.StatusTransitions[to]; !ok {
+				t.Errorf("** Testing transition table ** \n \t<unknown status %s of transitions from %s>", to, from)
+			}
+		}
+	}
+} // end of TestStatusTransitions function
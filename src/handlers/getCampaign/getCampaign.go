@@ -0,0 +1,139 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"firmware"
+	"policy"
+	"localserver"
+	"retry"
+	"types"
+	"fmt"
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// most devices that progress of a campaign is counted from, devices of every model are read from the tenant's partition
+const MAX_PROGRESS_DEVICES = 10000
+
+type SuccessResponse = types.CampaignResponse
+
+// devices table and firmware store of the handler, they are built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Firmware *firmware.Store
+	MaxDevices int // progress is counted from at most this many devices, see MAX_PROGRESS_DEVICES
+}
+
+// main AWS lambda function starting point.
+// It returns a campaign of caller's tenant with its progress. Progress isn't stored, it's counted from firmware
+// versions that devices of the campaign's model report by heartbeats, so it's as recent as their last heartbeats.
+func (ig *dynamoDBAPI) GetCampaign(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:read scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+	if denied := policy.Check(principal, policy.PERMISSION_FIRMWARE_READ); denied != nil {
+		return *denied, nil
+	}
+
+	id := request.PathParameters["id"]
+	if id == "" {
+		return apigw.ErrorResponse(404, "No ID Field Provided"), nil
+	}
+
+	campaign, err := ig.Firmware.GetCampaign(ctx, principal.TenantID, id)
+	if err == firmware.ErrCampaignNotFound {
+		return apigw.ErrorResponse(404, "Desired campaign with provided id was not founded"), nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	devices, complete, err := ig.devicesOfModel(ctx, principal.TenantID, campaign.DeviceModel)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	progress := firmware.Progress(campaign, devices)
+	progress.Partial = !complete
+	return apigw.JSONResponse(200, &SuccessResponse{Campaign: campaign, Progress: &progress}), nil
+}
+
+// function that returns devices of a model in the tenant, only the attributes that campaigns target by
+// and the reported firmware version are read. At most MaxDevices devices of the tenant are read, complete is false
+// when the tenant has more of them.
+func (ig *dynamoDBAPI) devicesOfModel(ctx context.Context, tenantId string, model string) (devices []types.Device, complete bool, err error) {
+	input := &dynamodb.QueryInput{
+		TableName: ig.TableName,
+		KeyConditionExpression: aws.String("tenantId = :tenantId"),
+		FilterExpression: aws.String("deviceModel = :model"),
+		ProjectionExpression: aws.String("id, deviceModel, tags, #status, firmwareVersion"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":tenantId":	{S: aws.String(tenantId)},
+			":model":		{S: aws.String(model)},
+		},
+	}
+
+	devices = []types.Device{}
+	for read := 0; read < ig.MaxDevices; {
+		// Limit bounds the items that are read, not the ones that pass the filter
+		input.Limit = aws.Int64(int64(ig.MaxDevices - read))
+
+		var output *dynamodb.QueryOutput
+		err := ig.Retry.Do(ctx, func() (err error) {
+			output, err = ig.DynamoDB.QueryWithContext(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, false, err
+		}
+
+		page := []types.Device{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(output.Items, &page); err != nil {
+			return nil, false, err
+		}
+		devices = append(devices, page...)
+
+		if len(output.LastEvaluatedKey) == 0 {
+			return devices, true, nil
+		}
+		input.ExclusiveStartKey = output.LastEvaluatedKey
+
+		// devices of other models are read too, they count as well
+		scanned := len(output.Items)
+		if output.ScannedCount != nil {
+			scanned = int(*output.ScannedCount)
+		}
+		read += scanned
+	}
+	return devices, false, nil
+}
+
+// newHandler wraps GetCampaign with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services. The campaigns table is only needed by firmware handlers, so it's checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	if len(services.Config.CampaignsTableName) == 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: []string{"CAMPAIGNS_TABLE_NAME is not set"}}
+	}
+	store := &firmware.Store{DynamoDB: services.DynamoDB, CampaignsTable: aws.String(services.Config.CampaignsTableName), Retry: services.Retry}
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Firmware: store, MaxDevices: MAX_PROGRESS_DEVICES}
+	return apigw.Chain(table.GetCampaign, apigw.Standard(services, auth.SCOPE_DEVICES_READ)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("getCampaign")...)
+}
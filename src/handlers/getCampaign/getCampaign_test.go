@@ -0,0 +1,143 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"firmware"
+	"retry"
+	"types"
+	"testing"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+	MaxDevices 					int // MAX_PROGRESS_DEVICES when it's not set
+}
+
+// A fakeDynamoDB instance for mocking test that emulates campaigns and devices tables of "tenant_test". Campaign
+// "c_first" rolls out "2.1.0" to devices of "thermo-2" at site berlin, its first stage includes buckets below 50.
+// Devices of berlin are "id_1" (bucket 95) and "id_2" (bucket 61) and "id_updated" (bucket 16), "id_4" is at
+// site paris. Devices are returned in two pages, campaign "c_error" fails.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+// a mocked version of DynamoDB's GetItem function, for the campaigns table
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	output := new(dynamodb.GetItemOutput)
+	id := *input.Key["id"].S
+	if id == "c_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	if *input.TableName == apigwtest.CAMPAIGNS_TABLE_NAME && *input.Key["tenantId"].S == "tenant_test" && id == "c_first" {
+		campaign := types.Campaign{ID: "c_first", Name: "sensor fix", DeviceModel: "thermo-2", Version: "2.1.0", Tags: map[string]string{"site": "berlin"}, Stages: []int{50, 100}, Status: types.CAMPAIGN_ACTIVE, CreatedAt: "2018-06-25T08:00:00Z", UpdatedAt: "2018-06-25T08:00:00Z"}
+		item, _ := dynamodbattribute.MarshalMap(campaign)
+		output.SetItem(item)
+	}
+	return output, nil
+}
+
+// a mocked version of DynamoDB's Query function, for the devices table
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	berlin := map[string]string{"site": "berlin"}
+	pages := [][]types.Device{
+		{
+			{ID: "id_1", DeviceModel: "thermo-2", Tags: berlin, FirmwareVersion: "2.0.0"},
+			{ID: "id_2", DeviceModel: "thermo-2", Tags: berlin, FirmwareVersion: "2.1.0"},
+		},
+		{
+			{ID: "id_updated", DeviceModel: "thermo-2", Tags: berlin, FirmwareVersion: "2.1.0"},
+			{ID: "id_4", DeviceModel: "thermo-2", Tags: map[string]string{"site": "paris"}, FirmwareVersion: "2.0.0"},
+		},
+	}
+
+	page := pages[0]
+	output := &dynamodb.QueryOutput{LastEvaluatedKey: map[string]*dynamodb.AttributeValue{"id": {S: aws.String("id_2")}}}
+	if input.ExclusiveStartKey != nil {
+		page = pages[1]
+		output.LastEvaluatedKey = nil
+	}
+	for _, device := range page {
+		item, _ := dynamodbattribute.MarshalMap(device)
+		output.Items = append(output.Items, item)
+	}
+	return output, nil
+}
+
+func TestGetCampaign(t *testing.T) {
+
+	campaign := func(id string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.VIEWER_API_KEY}, PathParameters: map[string]string{"id": id}}
+	}
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing campaign with progress **",
+			InputRequest:		campaign("c_first"),
+			ExpectedBody:		"{\n\t\"data\": {\n\t\t\"id\": \"c_first\",\n\t\t\"name\": \"sensor fix\",\n\t\t\"deviceModel\": \"thermo-2\",\n\t\t\"version\": \"2.1.0\",\n\t\t\"tags\": {\n\t\t\t\"site\": \"berlin\"\n\t\t},\n" +
+				"\t\t\"stages\": [\n\t\t\t50,\n\t\t\t100\n\t\t],\n\t\t\"stage\": 0,\n\t\t\"status\": \"active\",\n\t\t\"createdAt\": \"2018-06-25T08:00:00Z\",\n\t\t\"updatedAt\": \"2018-06-25T08:00:00Z\"\n\t},\n" +
+				"\t\"progress\": {\n\t\t\"percent\": 50,\n\t\t\"targeted\": 3,\n\t\t\"eligible\": 1,\n\t\t\"updated\": 2\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing progress of the first devices of a large tenant **",
+			InputRequest:		campaign("c_first"),
+			MaxDevices:			2,
+			ExpectedBody:		"{\n\t\"data\": {\n\t\t\"id\": \"c_first\",\n\t\t\"name\": \"sensor fix\",\n\t\t\"deviceModel\": \"thermo-2\",\n\t\t\"version\": \"2.1.0\",\n\t\t\"tags\": {\n\t\t\t\"site\": \"berlin\"\n\t\t},\n" +
+				"\t\t\"stages\": [\n\t\t\t50,\n\t\t\t100\n\t\t],\n\t\t\"stage\": 0,\n\t\t\"status\": \"active\",\n\t\t\"createdAt\": \"2018-06-25T08:00:00Z\",\n\t\t\"updatedAt\": \"2018-06-25T08:00:00Z\"\n\t},\n" +
+				"\t\"progress\": {\n\t\t\"percent\": 50,\n\t\t\"targeted\": 2,\n\t\t\"eligible\": 0,\n\t\t\"updated\": 1,\n\t\t\"partial\": true\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing missing campaign **",
+			InputRequest:		campaign("c_missing"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Desired campaign with provided id was not founded\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		campaign("c_error"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}",
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases
+		handler := newHandler(apigwtest.NewServices(&FakeDynamoDBAPI{}, nil))
+		if test.MaxDevices != 0 {
+			services := apigwtest.NewServices(&FakeDynamoDBAPI{}, nil)
+			store := &firmware.Store{DynamoDB: services.DynamoDB, CampaignsTable: aws.String(apigwtest.CAMPAIGNS_TABLE_NAME), Retry: retry.Default}
+			campaigns := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String("test_table_name"), Retry: retry.Default, Firmware: store, MaxDevices: test.MaxDevices}
+			handler = apigw.Chain(campaigns.GetCampaign, apigw.Standard(services, auth.SCOPE_DEVICES_READ)...)
+		}
+
+		// calls getCampaign.go's GetCampaign function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("GET", "/campaigns/{id}", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+
+} // end of TestGetCampaign function
@@ -1,14 +1,21 @@
 package main
 
 import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"devices"
+	"policy"
+	"localserver"
+	"retry"
+	"tracing"
 	"types"
 	"fmt"
-	"os"
+	"context"
 	"encoding/json"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-lambda-go/lambda"
 	"github.com/aws/aws-lambda-go/events"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
@@ -19,66 +26,49 @@ var	ERROR_INTERNAL_SERVERS_DATABAE = 2
 var	ERROR_NO_ITEM_FOUNDED = 3
 
 
-type SuccessResponse struct{
-	Device	types.Device	`json:"data"`
-}
+type SuccessResponse = types.DeviceResponse
 
+// devices table of the handler, it's built by newHandler from apigw.Services
 type dynamoDBAPI struct{
 	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
 }
 
-var databseStruct *types.DatabseStruct
-var dynamodbapi *dynamoDBAPI
-
-func init(){
-	databseStruct = new(types.DatabseStruct)
-	region := os.Getenv("AWS_REGION")
-	dynamodbapi = new(dynamoDBAPI) // crate a setter that  can be used for inserting
-	sess, err := session.NewSession(&aws.Config{Region: &region},)
-	databseStruct.SessionError = err
-	svc := dynamodb.New(sess)
-	dynamodbapi.DynamoDB = dynamodbiface.DynamoDBAPI(svc)
-	
-	if err != nil {
-		fmt.Println("There is an error while creating database session: " + err.Error())
-    }
-
-	// Get table name from OS's environment
-	fetchedTableName :=os.Getenv("DEVICES_TABLE_NAME")
-	if len(fetchedTableName)==0 {
-		databseStruct.TableName =  nil;
-		fmt.Println("It is not possible to fetch device tabel name")
-	}else{
-		databseStruct.TableName = aws.String(fetchedTableName)   
-	}
-}
-
-// get a device from DynamoDB database with provided id
-func (ig *dynamoDBAPI) getFromDatabase(id string) ( *dynamodb.GetItemOutput, error) {
+// get a device of a tenant from DynamoDB database with provided id,
+// devices are keyed by tenantId (partition) and id (sort) so other tenants' devices are never returned.
+func (ig *dynamoDBAPI) getFromDatabase(ctx context.Context, tenantId string, id string) ( *dynamodb.GetItemOutput, error) {
 	
 	var input = &dynamodb.GetItemInput{
-		TableName: databseStruct.TableName,
+		TableName: ig.TableName,
 		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
 			"id": {
 				S: aws.String(id),
 			},
 		},
 	}
 	
-	result, err := ig.DynamoDB.GetItem(input)
+	// throttled calls are retried
+	var result *dynamodb.GetItemOutput
+	err := ig.Retry.Do(ctx, func() (err error) {
+		result, err = ig.DynamoDB.GetItemWithContext(ctx, input)
+		return err
+	})
 	return result, err
 }
 
-
 // main AWS lambda function starting point.
 // It gets an id from client, parse it and tries to get corresponding device fromdynamodb.
-func GetDeviceById(request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// there is some internal server error 
-	if databseStruct.SessionError != nil || databseStruct.TableName == nil {
-		return events.APIGatewayProxyResponse{
-			Body:	createErrorResponseJson(ERROR_INTERNAL_SERVERS_DATABAE),
-			StatusCode:	404,
-		}, nil
+func (ig *dynamoDBAPI) GetDeviceById(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	// only callers with devices:read scope get here (see newHandler), and they only see devices of their own tenant
+	principal := auth.FromContext(ctx)
+
+	// caller's roles must allow reading devices
+	if denied := policy.Check(principal, policy.PERMISSION_DEVICES_READ); denied != nil {
+		return *denied, nil
 	}
 
 	// get requested id from APIGatewayProxyRequest 
@@ -92,8 +82,18 @@ func GetDeviceById(request events.APIGatewayProxyRequest) (events.APIGatewayProx
 		}, nil
 	}
 
-	result, err := dynamodbapi.getFromDatabase(id)
+	result, err := ig.getFromDatabase(ctx, principal.TenantID, id)
+
+	// timed out or throttled calls are answered by apigw.ErrorMapping with 503 and Retry-After
+	if err != nil && apigw.IsUnavailable(err) {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	_, span := tracing.Start(ctx, "serialize response")
 	validationResult := validateDatabaseResult(result, err)
+	span.Finish()
+	if validationResult.StatusCode == 404 {
+		devices.LogCrossTenantAccess(ctx, ig.DynamoDB, ig.TableName, principal, id)
+	}
 	return validationResult , nil
 }
 
@@ -147,12 +147,24 @@ func createSuccessResponseJson(result *dynamodb.GetItemOutput) (jsonString strin
 	dynamodbattribute.UnmarshalMap(result.Item, &item)
 	
 	successResponse := SuccessResponse {
-		item,
+		Device: item,
 	}
 	successResponseJson, _ := json.MarshalIndent(&successResponse, "", "\t")
 	return string(successResponseJson)
 }
 
+// newHandler wraps GetDeviceById with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services.
+func newHandler(services *apigw.Services) apigw.Handler {
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry}
+	return apigw.Chain(table.GetDeviceById, apigw.Standard(services, auth.SCOPE_DEVICES_READ)...)
+}
+
 func main(){
-	lambda.Start(GetDeviceById)
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("getDeviceById")...)
 }
@@ -1,10 +1,16 @@
 package main
 
 import(
-	"types"
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"retry"
 	"testing"
+	"context"
 	"errors" 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
 	"github.com/aws/aws-lambda-go/events"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
@@ -15,6 +21,7 @@ type TestCase struct {
 	Name 						string
 	InputId 					events.APIGatewayProxyRequest
 	InputIdString 				string
+	InputTenantId 				string
 	DatabaseOutput 				dynamodb.GetItemOutput
 	Error 						error
 	ExpectedBody 				string
@@ -22,22 +29,24 @@ type TestCase struct {
 	ExpectedDatabaseOutput 		dynamodb.GetItemOutput
 }
 
-
-
 // A fakeDynamoDB instance for mocking test that emulates real DynamoDB
 type FakeDynamoDBAPI struct {
 	dynamodbiface.DynamoDBAPI
 }
 
-
 // a mocked version of DynamoDB's GetItem function.
 // in testing state, instead of calling real DynamoDB's GetItem, we try to emulate it.
 // Get function of getDeviceById.go calls this function in Testing state.  
-func (fd *FakeDynamoDBAPI) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetItemOutput, error) {
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
 	output := new(dynamodb.GetItemOutput)
+	tenantId := input.Key["tenantId"].S
 	id := input.Key["id"].S
+
+	if *id == "id_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
     
-	if *id == "id_test" {
+	if *tenantId == "tenant_test" && *id == "id_test" {
 		output.SetItem(
 			map[string]*dynamodb.AttributeValue{
 				"id": &dynamodb.AttributeValue{S: aws.String("id_test")},
@@ -49,9 +58,41 @@ func (fd *FakeDynamoDBAPI) GetItem(input *dynamodb.GetItemInput) (*dynamodb.GetI
 		)
 	}    
 
+	// attributes are stored as a native map
+	if *tenantId == "tenant_test" && *id == "id_attributes" {
+		output.SetItem(
+			map[string]*dynamodb.AttributeValue{
+				"id": &dynamodb.AttributeValue{S: aws.String("id_attributes")},
+				"deviceModel": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+				"name": &dynamodb.AttributeValue{S: aws.String("name_test")},
+				"note": &dynamodb.AttributeValue{S: aws.String("note_test")},
+				"serial": &dynamodb.AttributeValue{S: aws.String("serial_test")},
+				"attributes": &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{
+					"samplingRate": {N: aws.String("2.5")},
+					"enabled": {BOOL: aws.Bool(true)},
+					"network": {M: map[string]*dynamodb.AttributeValue{"ports": {L: []*dynamodb.AttributeValue{{N: aws.String("80")}}}}},
+				}},
+			},
+		)
+	}
+
 	return output, nil
 }
 
+// A fake devices table that can't be reached, like the database of baseline tests
+type UnreachableDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+func (fd *UnreachableDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	return nil, errors.New("Unexpected Error has occured")
+}
+
+// an admin key that only has devices:write scope, keys of apigwtest have devices:read too
+const WRITE_API_KEY = "writekey." + apigwtest.SECRET
+
+var testKeys = &apigwtest.FakeKeysDynamoDBAPI{Keys: []auth.ApiKey{{ID: "writekey", Scopes: []string{auth.SCOPE_DEVICES_WRITE}, Roles: []string{"admin"}, TenantID: apigwtest.TENANT}}}
+
 func TestGetFromDatabase(t *testing.T) {
 
 	// a valid Dynamodb's GetItemOutput
@@ -71,24 +112,24 @@ func TestGetFromDatabase(t *testing.T) {
 		{
 			Name:					"** Requested id exists **",
 			InputIdString:			"id_test",
+			InputTenantId:			"tenant_test",
 			ExpectedDatabaseOutput:	output,
 		},
 		{
 			Name:					"** Requested id does not exists **",
 			InputIdString:			"id_test_no",
+			InputTenantId:			"tenant_test",
 			ExpectedDatabaseOutput:	dynamodb.GetItemOutput{},
 		},
 	}
 
 	// create mocked database.
-	getter := new(dynamoDBAPI)
-	getter.DynamoDB = &FakeDynamoDBAPI{}
-
+	getter := &dynamoDBAPI{DynamoDB: &FakeDynamoDBAPI{}, TableName: aws.String("test_table_name"), Retry: retry.Default}
 
 	for _, test := range testCases {
 
 		// calls getDevicebyId.go's Get function.
-		response, _ := getter.getFromDatabase(test.InputIdString)
+		response, _ := getter.getFromDatabase(context.Background(), test.InputTenantId, test.InputIdString)
 
 		if len(response.GoString()) != len(test.ExpectedDatabaseOutput.GoString()) {
 			t.Errorf("%s \n \t<expected output: \n%s> \n<resulted output: \n%s>", test.Name, test.ExpectedDatabaseOutput.GoString(), response.GoString())
@@ -96,7 +137,16 @@ func TestGetFromDatabase(t *testing.T) {
 	}
 } // end of TestGet function
 
+func TestGetFromDatabaseOfAnotherTenant(t *testing.T) {
 
+	getter := &dynamoDBAPI{DynamoDB: &FakeDynamoDBAPI{}, TableName: aws.String("test_table_name"), Retry: retry.Default}
+
+	// devices are only found in the tenant they belong to
+	response, err := getter.getFromDatabase(context.Background(), "other_tenant", "id_test")
+	if err != nil || len(response.Item) != 0 {
+		t.Errorf("** Requested id exists in another tenant ** \n \t<expected output: \n%s> \n<resulted output: \n%s>", dynamodb.GetItemOutput{}.GoString(), response.GoString())
+	}
+} // end of TestGetFromDatabaseOfAnotherTenant function
 
 func TestGetDeviceById(t *testing.T) {
 
@@ -118,23 +168,87 @@ func TestGetDeviceById(t *testing.T) {
 	}
 
     
-	for _, test := range testCases {
+	runTestCases(t, newHandler(apigwtest.NewServices(&UnreachableDynamoDBAPI{}, testKeys)), testCases)
 
-		databseStruct = new(types.DatabseStruct)
-		databseStruct.TableName = aws.String("test_table_name");
-		// calls getDeviceById.go's AddDevice function.
-		response,_ := GetDeviceById(test.InputId)
+} // end of TestAddDevice function
 
-		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
-			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
-		}
+func TestGetDeviceByIdAuthorization(t *testing.T) {
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing missing api key **",
+			InputId:			events.APIGatewayProxyRequest{Headers: map[string]string{}, PathParameters: map[string]string{
+										"id": "id_test",},},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 401,\n\t\t\"message\": \"No API key provided\"\n\t}\n}",
+			ExpectedStatusCode:	401,
+		},
+		{
+			Name:				"** Testing api key without devices:read scope **",
+			InputId:			events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": WRITE_API_KEY}, PathParameters: map[string]string{
+										"id": "id_test",},},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 403,\n\t\t\"message\": \"API key lacks required scope: devices:read\"\n\t}\n}",
+			ExpectedStatusCode:	403,
+		},
 	}
 
-} // end of TestAddDevice function
+	runTestCases(t, newHandler(apigwtest.NewServices(&FakeDynamoDBAPI{}, testKeys)), testCases)
 
+} // end of TestGetDeviceByIdAuthorization function
 
+func TestGetDeviceByIdFromDatabase(t *testing.T) {
 
+	testCases := []TestCase{
+		{
+			Name:				"** Testing existing device **",
+			InputId:			events.APIGatewayProxyRequest{PathParameters: map[string]string{
+										"id": "id_test",},},
+			ExpectedBody:		"{\n\t\"data\": {\n\t\t\"id\": \"id_test\",\n\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\"name\": \"name_test\",\n\t\t\"note\": \"note_test\",\n\t\t\"serial\": \"serial_test\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing device with attributes **",
+			InputId:			events.APIGatewayProxyRequest{PathParameters: map[string]string{
+										"id": "id_attributes",},},
+			ExpectedBody:		"{\n\t\"data\": {\n\t\t\"id\": \"id_attributes\",\n\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\"name\": \"name_test\",\n\t\t\"note\": \"note_test\",\n\t\t\"serial\": \"serial_test\",\n\t\t\"attributes\": {\n\t\t\t\"enabled\": true,\n\t\t\t\"network\": {\n\t\t\t\t\"ports\": [\n\t\t\t\t\t80\n\t\t\t\t]\n\t\t\t},\n\t\t\t\"samplingRate\": 2.5\n\t\t}\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputId:			events.APIGatewayProxyRequest{PathParameters: map[string]string{
+										"id": "id_error",},},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}",
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	runTestCases(t, newHandler(apigwtest.NewServices(&FakeDynamoDBAPI{}, testKeys)), testCases)
+
+} // end of TestGetDeviceByIdFromDatabase function
+
+// runTestCases sends requests of testCases to handler and checks their responses
+func runTestCases(t *testing.T, handler apigw.Handler, testCases []TestCase) {
+
+	for _, test := range testCases {
+
+		// requests without explicit headers are sent with a valid read key
+		if test.InputId.Headers == nil {
+			test.InputId.Headers = map[string]string{"X-Api-Key": apigwtest.VIEWER_API_KEY}
+		}
+
+		// calls getDeviceById.go's GetDeviceById function.
+		response,_ := handler(context.Background(), test.InputId)
 
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("GET", "/devices/{id}", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+
+}
 
 func TestValidateDatabaseResult(t *testing.T) {
 
@@ -175,7 +289,6 @@ func TestValidateDatabaseResult(t *testing.T) {
 		},
 	}
 
-
 	for _, test := range testCases {
 
 		response := validateDatabaseResult(&test.DatabaseOutput, test.Error)
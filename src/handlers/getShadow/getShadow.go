@@ -0,0 +1,125 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"devices"
+	"policy"
+	"localserver"
+	"retry"
+	"shadows"
+	"types"
+	"fmt"
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var ErrDeviceNotFound = errors.New("device not found")
+
+type SuccessResponse = types.ShadowResponse
+
+// devices table of the handler and the store of shadows, they are built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Shadows *shadows.Store
+}
+
+// main AWS lambda function starting point.
+// It returns the shadow of a device of caller's tenant: desired and reported state, the delta that the device still
+// has to apply and versions of keys. Devices that never had a shadow get empty documents at version 0.
+func (ig *dynamoDBAPI) GetShadow(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:read scope get here (see newHandler), and they only see devices of their own tenant
+	principal := auth.FromContext(ctx)
+
+	if denied := policy.Check(principal, policy.PERMISSION_DEVICES_READ); denied != nil {
+		return *denied, nil
+	}
+
+	id := request.PathParameters["id"]
+	if id == "" {
+		return apigw.ErrorResponse(404, "No ID Field Provided"), nil
+	}
+
+	legacy, err := ig.getItemFromDatabase(ctx, principal.TenantID, id)
+	if err == ErrDeviceNotFound {
+		devices.LogCrossTenantAccess(ctx, ig.DynamoDB, ig.TableName, principal, id)
+		return apigw.ErrorResponse(404, "Desired device with provided id was not founded"), nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	// shadows that are still kept in the device item are shown until they are moved by their next change
+	shadow, found, err := ig.Shadows.Get(ctx, principal.TenantID, id)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	if !found {
+		shadow = legacy
+	}
+
+	return apigw.JSONResponse(200, &SuccessResponse{Shadow: shadow.WithDelta()}), nil
+}
+
+// function that returns the shadow that a device of the tenant still keeps in its item (see shadows.Legacy), ErrDeviceNotFound
+// when the device doesn't exist. only id and shadow of the device are read.
+func (ig *dynamoDBAPI) getItemFromDatabase(ctx context.Context, tenantId string, id string) (types.Shadow, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: ig.TableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ProjectionExpression: aws.String("id, #shadow"),
+		ExpressionAttributeNames: map[string]*string{"#shadow": aws.String(shadows.LEGACY_ATTRIBUTE)},
+	}
+
+	var output *dynamodb.GetItemOutput
+	err := ig.Retry.Do(ctx, func() (err error) {
+		output, err = ig.DynamoDB.GetItemWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return types.Shadow{}, err
+	}
+	if len(output.Item) == 0 {
+		return types.Shadow{}, ErrDeviceNotFound
+	}
+
+	shadow, _, err := shadows.Legacy(output.Item)
+	return shadow, err
+}
+
+// newHandler wraps GetShadow with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services. The shadows table is only needed by shadow handlers, so it's checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	if len(services.Config.ShadowsTableName) == 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: []string{"SHADOWS_TABLE_NAME is not set"}}
+	}
+	store := &shadows.Store{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.ShadowsTableName), Retry: services.Retry}
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Shadows: store}
+	return apigw.Chain(table.GetShadow, apigw.Standard(services, auth.SCOPE_DEVICES_READ)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("getShadow")...)
+}
@@ -0,0 +1,141 @@
+package main
+
+import(
+	"api"
+	"apigwtest"
+	"types"
+	"testing"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+}
+
+// A fakeDynamoDB instance for mocking test that emulates real DynamoDB, "id_test" of "tenant_test" doesn't have
+// a shadow and "id_shadow" has one at version 4 in the shadows table. "id_legacy" keeps the same shadow in its device item.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+// a mocked version of DynamoDB's Query function on the id index, it's used for logging cross-tenant access
+// attempts and devices of other tenants aren't known
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{}, nil
+}
+
+func storedShadow() map[string]*dynamodb.AttributeValue {
+	shadow, _ := dynamodbattribute.MarshalMap(map[string]interface{}{
+		"desired":	map[string]interface{}{"samplingRate": 5},
+		"reported":	map[string]interface{}{"samplingRate": 1, "firmware": "2.4.1"},
+		"metadata":	types.ShadowMetadata{
+			Desired:	map[string]types.ShadowKeyMetadata{"samplingRate": {Version: 4, UpdatedAt: "2018-06-26T07:00:00Z"}},
+			Reported:	map[string]types.ShadowKeyMetadata{"samplingRate": {Version: 3, UpdatedAt: "2018-06-26T06:00:00Z"}, "firmware": {Version: 1, UpdatedAt: "2018-06-25T06:00:00Z"}},
+		},
+		"version":	4,
+	})
+	return shadow
+}
+
+// a mocked version of DynamoDB's GetItem function
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	output := new(dynamodb.GetItemOutput)
+	if *input.TableName == "test_shadows_table_name" {
+		if *input.Key["device"].S == "tenant_test#id_shadow" {
+			output.SetItem(storedShadow())
+		}
+		return output, nil
+	}
+
+	id := *input.Key["id"].S
+	if id == "id_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	if *input.Key["tenantId"].S != "tenant_test" {
+		return output, nil
+	}
+
+	switch id {
+	case "id_test", "id_shadow":
+		output.SetItem(map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}})
+	case "id_legacy":
+		output.SetItem(map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}, "shadow": {M: storedShadow()}})
+	}
+	return output, nil
+}
+
+func TestGetShadow(t *testing.T) {
+
+	shadow := func(id string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.VIEWER_API_KEY}, PathParameters: map[string]string{"id": id}}
+	}
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing shadow with delta **",
+			InputRequest:		shadow("id_shadow"),
+			ExpectedBody:		"{\n\t\"data\": {\n\t\t\"desired\": {\n\t\t\t\"samplingRate\": 5\n\t\t},\n\t\t\"reported\": {\n\t\t\t\"firmware\": \"2.4.1\",\n\t\t\t\"samplingRate\": 1\n\t\t},\n\t\t\"delta\": {\n\t\t\t\"samplingRate\": 5\n\t\t},\n" +
+				"\t\t\"metadata\": {\n\t\t\t\"desired\": {\n\t\t\t\t\"samplingRate\": {\n\t\t\t\t\t\"version\": 4,\n\t\t\t\t\t\"updatedAt\": \"2018-06-26T07:00:00Z\"\n\t\t\t\t}\n\t\t\t},\n" +
+				"\t\t\t\"reported\": {\n\t\t\t\t\"firmware\": {\n\t\t\t\t\t\"version\": 1,\n\t\t\t\t\t\"updatedAt\": \"2018-06-25T06:00:00Z\"\n\t\t\t\t},\n\t\t\t\t\"samplingRate\": {\n\t\t\t\t\t\"version\": 3,\n\t\t\t\t\t\"updatedAt\": \"2018-06-26T06:00:00Z\"\n\t\t\t\t}\n\t\t\t}\n\t\t},\n" +
+				"\t\t\"version\": 4\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing shadow that is still kept in the device item **",
+			InputRequest:		shadow("id_legacy"),
+			ExpectedBody:		"{\n\t\"data\": {\n\t\t\"desired\": {\n\t\t\t\"samplingRate\": 5\n\t\t},\n\t\t\"reported\": {\n\t\t\t\"firmware\": \"2.4.1\",\n\t\t\t\"samplingRate\": 1\n\t\t},\n\t\t\"delta\": {\n\t\t\t\"samplingRate\": 5\n\t\t},\n" +
+				"\t\t\"metadata\": {\n\t\t\t\"desired\": {\n\t\t\t\t\"samplingRate\": {\n\t\t\t\t\t\"version\": 4,\n\t\t\t\t\t\"updatedAt\": \"2018-06-26T07:00:00Z\"\n\t\t\t\t}\n\t\t\t},\n" +
+				"\t\t\t\"reported\": {\n\t\t\t\t\"firmware\": {\n\t\t\t\t\t\"version\": 1,\n\t\t\t\t\t\"updatedAt\": \"2018-06-25T06:00:00Z\"\n\t\t\t\t},\n\t\t\t\t\"samplingRate\": {\n\t\t\t\t\t\"version\": 3,\n\t\t\t\t\t\"updatedAt\": \"2018-06-26T06:00:00Z\"\n\t\t\t\t}\n\t\t\t}\n\t\t},\n" +
+				"\t\t\"version\": 4\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing device without shadow **",
+			InputRequest:		shadow("id_test"),
+			ExpectedBody:		"{\n\t\"data\": {\n\t\t\"desired\": {},\n\t\t\"reported\": {},\n\t\t\"delta\": {},\n\t\t\"metadata\": {\n\t\t\t\"desired\": {},\n\t\t\t\"reported\": {}\n\t\t},\n\t\t\"version\": 0\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing missing device **",
+			InputRequest:		shadow("id_missing"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Desired device with provided id was not founded\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		shadow("id_error"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}",
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases
+		handler := newHandler(apigwtest.NewServices(&FakeDynamoDBAPI{}, nil))
+
+		// calls getShadow.go's GetShadow function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("GET", "/devices/{id}/shadow", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+
+} // end of TestGetShadow function
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"devices"
+	"policy"
+	"localserver"
+	"retry"
+	"telemetry"
+	"types"
+	"fmt"
+	"time"
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// range of a query when ?from= isn't sent
+const DEFAULT_RANGE = time.Hour
+
+// the range is divided into this many buckets when ?step= isn't sent
+const DEFAULT_BUCKETS = 60
+
+// most buckets of a query, so responses stay small
+const MAX_BUCKETS = 1000
+
+// longest range of a query, readings of a range are read from the table before they are downsampled
+const MAX_RANGE = 31 * 24 * time.Hour
+
+type SuccessResponse = types.TelemetryResponse
+
+// a parsed query, readings of Metric in [From, To) are downsampled into buckets of Step
+type telemetryQuery struct {
+	Metric	string
+	From	time.Time
+	To		time.Time
+	Step	time.Duration
+}
+
+// devices table and telemetry store of the handler, they are built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Now func() time.Time
+	Telemetry *telemetry.Store
+}
+
+// main AWS lambda function starting point.
+// It returns min, max and avg of ?metric= of a device per ?step= (like 5m) between ?from= and ?to= (RFC 3339 times).
+// to is now and from is an hour before it by default, the range is divided into 60 buckets when step isn't sent.
+// Ranges longer than MAX_RANGE, or with more readings than the store reads, are rejected.
+func (ig *dynamoDBAPI) GetTelemetry(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:read scope get here (see newHandler), and they only see devices of their own tenant
+	principal := auth.FromContext(ctx)
+
+	if denied := policy.Check(principal, policy.PERMISSION_TELEMETRY_READ); denied != nil {
+		return *denied, nil
+	}
+
+	id := request.PathParameters["id"]
+	if id == "" {
+		return apigw.ErrorResponse(404, "No ID Field Provided"), nil
+	}
+
+	query, err := parseQuery(request.QueryStringParameters, ig.Now())
+	if err != nil {
+		return apigw.ErrorResponse(400, err.Error()), nil
+	}
+
+	exists, err := devices.Exists(ctx, ig.DynamoDB, ig.TableName, ig.Retry, principal.TenantID, id)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	if !exists {
+		devices.LogCrossTenantAccess(ctx, ig.DynamoDB, ig.TableName, principal, id)
+		return apigw.ErrorResponse(404, "Desired device with provided id was not founded"), nil
+	}
+
+	points, err := ig.Telemetry.Query(ctx, principal.TenantID, id, query.Metric, query.From, query.To)
+	if err == telemetry.ErrTooManyReadings {
+		return apigw.ErrorResponse(400, "There are too many readings between from and to, please query a shorter range"), nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	return apigw.JSONResponse(200, &SuccessResponse{
+		Metric:		query.Metric,
+		From:		query.From.UTC().Format(time.RFC3339Nano),
+		To:			query.To.UTC().Format(time.RFC3339Nano),
+		Step:		query.Step.String(),
+		Buckets:	telemetry.Downsample(points, query.From, query.To, query.Step),
+	}), nil
+}
+
+// parseQuery parses query string parameters, metric is required and the others have defaults
+func parseQuery(parameters map[string]string, now time.Time) (telemetryQuery, error) {
+	query := telemetryQuery{Metric: parameters["metric"], To: now}
+	if len(query.Metric) == 0 {
+		return query, fmt.Errorf("metric is required")
+	}
+	if violations := api.ValidateMetricName(query.Metric); len(violations) != 0 {
+		return query, fmt.Errorf("metric %s: %s", violations[0].Message, query.Metric)
+	}
+
+	var err error
+	if value := parameters["to"]; len(value) != 0 {
+		if query.To, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return query, fmt.Errorf("to must be a date-time like 2018-06-26T08:00:00Z: %s", value)
+		}
+	}
+	query.From = query.To.Add(-DEFAULT_RANGE)
+	if value := parameters["from"]; len(value) != 0 {
+		if query.From, err = time.Parse(time.RFC3339Nano, value); err != nil {
+			return query, fmt.Errorf("from must be a date-time like 2018-06-26T08:00:00Z: %s", value)
+		}
+	}
+	if !query.From.Before(query.To) {
+		return query, fmt.Errorf("from must be before to")
+	}
+	if query.To.Sub(query.From) > MAX_RANGE {
+		return query, fmt.Errorf("from must be at most %s before to", MAX_RANGE)
+	}
+
+	// default step is rounded up to whole seconds, so buckets start at readable times
+	query.Step = (query.To.Sub(query.From) / DEFAULT_BUCKETS + time.Second - 1).Truncate(time.Second)
+	if value := parameters["step"]; len(value) != 0 {
+		query.Step, err = time.ParseDuration(value)
+		if err != nil || query.Step < time.Second {
+			return query, fmt.Errorf("step must be a duration of at least 1s like 5m: %s", value)
+		}
+	}
+	if buckets := (query.To.Sub(query.From) + query.Step - 1) / query.Step; buckets > MAX_BUCKETS {
+		return query, fmt.Errorf("step is too small, at most %d buckets can be returned but there are %d", MAX_BUCKETS, buckets)
+	}
+	return query, nil
+}
+
+// newHandler wraps GetTelemetry with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services. The telemetry table is only needed by telemetry handlers, so it's checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	if len(services.Config.TelemetryTableName) == 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: []string{"TELEMETRY_TABLE_NAME is not set"}}
+	}
+	store := &telemetry.Store{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.TelemetryTableName), Retry: services.Retry, Retention: services.Config.TelemetryRetention}
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Now: time.Now, Telemetry: store}
+	return apigw.Chain(table.GetTelemetry, apigw.Standard(services, auth.SCOPE_DEVICES_READ)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("getTelemetry")...)
+}
@@ -0,0 +1,224 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"retry"
+	"telemetry"
+	"types"
+	"testing"
+	"context"
+	"strconv"
+	"time"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+}
+
+// A fakeDynamoDB instance for mocking test that emulates real DynamoDB, only "id_test" of "tenant_test" exists
+// in devices table. It has a temperature reading every 10 minutes from 07:00 to 07:50, and a humidity reading at 07:30.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+// a mocked version of DynamoDB's GetItem function
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	id := *input.Key["id"].S
+	if id == "id_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	output := new(dynamodb.GetItemOutput)
+	if *input.Key["tenantId"].S == "tenant_test" && id == "id_test" {
+		output.SetItem(map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}})
+	}
+	return output, nil
+}
+
+// a mocked version of DynamoDB's Query function, it returns readings of the time range with the queried metric only
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	if input.IndexName != nil && *input.IndexName == types.DEVICES_ID_INDEX {
+		// cross-tenant access attempts are logged, devices of other tenants aren't known
+		return &dynamodb.QueryOutput{}, nil
+	}
+	if *input.ExpressionAttributeValues[":device"].S != "tenant_test#id_test" {
+		return nil, errors.New("Readings of another device are queried")
+	}
+	from, _ := strconv.ParseInt(*input.ExpressionAttributeValues[":from"].N, 10, 64)
+	to, _ := strconv.ParseInt(*input.ExpressionAttributeValues[":to"].N, 10, 64)
+	metric := *input.ExpressionAttributeNames["#metric"]
+
+	readings := map[string]map[int64]string{
+		"temperature":	{1529996400000: "20", 1529997000000: "22", 1529997600000: "21", 1529998200000: "25", 1529998800000: "19.5", 1529999400000: "20.5"},
+		"humidity":		{1529998200000: "40"},
+	}
+	output := &dynamodb.QueryOutput{}
+	for at := int64(1529996400000); at <= 1529999400000; at += 600000 {
+		if at < from || at > to {
+			continue
+		}
+		item := map[string]*dynamodb.AttributeValue{"at": {N: aws.String(strconv.FormatInt(at, 10))}}
+		if value, ok := readings[metric][at]; ok {
+			item["values"] = &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{metric: {N: aws.String(value)}}}
+		}
+		output.Items = append(output.Items, item)
+	}
+	return output, nil
+}
+
+// a key of the tenant without roles
+const NO_ROLES_API_KEY = "norolekey." + apigwtest.SECRET
+
+var testKeys = &apigwtest.FakeKeysDynamoDBAPI{Keys: []auth.ApiKey{{ID: "norolekey", Scopes: []string{auth.SCOPE_DEVICES_READ}, Roles: []string{}, TenantID: apigwtest.TENANT}}}
+
+func TestGetTelemetry(t *testing.T) {
+
+	query := func(id string, parameters map[string]string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": id}, QueryStringParameters: parameters}
+	}
+	badRequest := func(message string) string {
+		return "{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"" + message + "\"\n\t}\n}"
+	}
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing key without roles can't read telemetry **",
+			InputRequest:		events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": NO_ROLES_API_KEY}, PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 403,\n\t\t\"message\": \"Operation is not permitted: no role is assigned to the caller\"\n\t}\n}",
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name:				"** Testing buckets of the last hour by default **",
+			InputRequest:		query("id_test", map[string]string{"metric": "humidity"}),
+			ExpectedBody:		"{\n\t\"metric\": \"humidity\",\n\t\"from\": \"2018-06-26T07:00:00Z\",\n\t\"to\": \"2018-06-26T08:00:00Z\",\n\t\"step\": \"1m0s\",\n\t\"data\": [\n\t\t{\n\t\t\t\"start\": \"2018-06-26T07:30:00Z\",\n\t\t\t\"count\": 1,\n\t\t\t\"min\": 40,\n\t\t\t\"max\": 40,\n\t\t\t\"avg\": 40\n\t\t}\n\t]\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing min, max and avg per step **",
+			InputRequest:		query("id_test", map[string]string{"metric": "temperature", "from": "2018-06-26T07:00:00Z", "to": "2018-06-26T07:45:00Z", "step": "30m"}),
+			ExpectedBody:		"{\n\t\"metric\": \"temperature\",\n\t\"from\": \"2018-06-26T07:00:00Z\",\n\t\"to\": \"2018-06-26T07:45:00Z\",\n\t\"step\": \"30m0s\",\n\t\"data\": [\n\t\t{\n\t\t\t\"start\": \"2018-06-26T07:00:00Z\",\n\t\t\t\"count\": 3,\n\t\t\t\"min\": 20,\n\t\t\t\"max\": 22,\n\t\t\t\"avg\": 21\n\t\t},\n\t\t{\n\t\t\t\"start\": \"2018-06-26T07:30:00Z\",\n\t\t\t\"count\": 2,\n\t\t\t\"min\": 19.5,\n\t\t\t\"max\": 25,\n\t\t\t\"avg\": 22.25\n\t\t}\n\t]\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing metric without readings **",
+			InputRequest:		query("id_test", map[string]string{"metric": "pressure", "from": "2018-06-26T06:00:00Z"}),
+			ExpectedBody:		"{\n\t\"metric\": \"pressure\",\n\t\"from\": \"2018-06-26T06:00:00Z\",\n\t\"to\": \"2018-06-26T08:00:00Z\",\n\t\"step\": \"2m0s\",\n\t\"data\": []\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing missing metric **",
+			InputRequest:		query("id_test", nil),
+			ExpectedBody:		badRequest("metric is required"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing invalid metric **",
+			InputRequest:		query("id_test", map[string]string{"metric": "wind speed"}),
+			ExpectedBody:		badRequest("metric must match pattern ^[A-Za-z0-9_.-]+$: wind speed"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing invalid time **",
+			InputRequest:		query("id_test", map[string]string{"metric": "temperature", "from": "yesterday"}),
+			ExpectedBody:		badRequest("from must be a date-time like 2018-06-26T08:00:00Z: yesterday"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing from after to **",
+			InputRequest:		query("id_test", map[string]string{"metric": "temperature", "from": "2018-06-26T09:00:00Z"}),
+			ExpectedBody:		badRequest("from must be before to"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing too long range **",
+			InputRequest:		query("id_test", map[string]string{"metric": "temperature", "from": "2018-05-01T00:00:00Z"}),
+			ExpectedBody:		badRequest("from must be at most 744h0m0s before to"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing invalid step **",
+			InputRequest:		query("id_test", map[string]string{"metric": "temperature", "step": "500ms"}),
+			ExpectedBody:		badRequest("step must be a duration of at least 1s like 5m: 500ms"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing too many buckets **",
+			InputRequest:		query("id_test", map[string]string{"metric": "temperature", "step": "1s"}),
+			ExpectedBody:		badRequest("step is too small, at most 1000 buckets can be returned but there are 3600"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing unknown device **",
+			InputRequest:		query("id_missing", map[string]string{"metric": "temperature"}),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Desired device with provided id was not founded\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		query("id_error", map[string]string{"metric": "temperature"}),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}",
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases, queries end at a fixed time by default
+		fake := &FakeDynamoDBAPI{}
+		services := apigwtest.NewServices(fake, testKeys)
+		store := &telemetry.Store{DynamoDB: fake, TableName: aws.String("test_telemetry_table_name"), Retry: retry.Default, Retention: services.Config.TelemetryRetention}
+		devices := &dynamoDBAPI{DynamoDB: fake, TableName: aws.String("test_table_name"), Retry: retry.Default, Now: func() time.Time { return time.Unix(1530000000, 0) }, Telemetry: store}
+		handler := apigw.Chain(devices.GetTelemetry, apigw.Standard(services, auth.SCOPE_DEVICES_READ)...)
+
+		// requests without explicit headers are sent with a viewer key
+		if test.InputRequest.Headers == nil {
+			test.InputRequest.Headers = map[string]string{"X-Api-Key": apigwtest.VIEWER_API_KEY}
+		}
+
+		// calls getTelemetry.go's GetTelemetry function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("GET", "/devices/{id}/telemetry", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+
+} // end of TestGetTelemetry function
+
+func TestTooManyReadings(t *testing.T) {
+
+	// the last hour has 6 readings, the store reads at most 5
+	fake := &FakeDynamoDBAPI{}
+	services := apigwtest.NewServices(fake, testKeys)
+	store := &telemetry.Store{DynamoDB: fake, TableName: aws.String("test_telemetry_table_name"), Retry: retry.Default, Retention: services.Config.TelemetryRetention, MaxReadings: 5}
+	devices := &dynamoDBAPI{DynamoDB: fake, TableName: aws.String("test_table_name"), Retry: retry.Default, Now: func() time.Time { return time.Unix(1530000000, 0) }, Telemetry: store}
+	handler := apigw.Chain(devices.GetTelemetry, apigw.Standard(services, auth.SCOPE_DEVICES_READ)...)
+
+	request := events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.VIEWER_API_KEY}, PathParameters: map[string]string{"id": "id_test"}, QueryStringParameters: map[string]string{"metric": "temperature"}}
+	response, _ := handler(context.Background(), request)
+	for _, problem := range api.CheckResponse("GET", "/devices/{id}/telemetry", response) {
+		t.Errorf("** Testing too many readings ** \n \t<response drifted from the document: %s>", problem)
+	}
+	expectedBody := "{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"There are too many readings between from and to, please query a shorter range\"\n\t}\n}"
+	if response.StatusCode != 400 || response.Body != expectedBody {
+		t.Errorf("** Testing too many readings ** \n \t<expected error-code: 400> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", response.StatusCode, expectedBody, response.Body)
+	}
+
+} // end of TestTooManyReadings function
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"jwt"
+	"config"
+	"localserver"
+	"types"
+	"fmt"
+	"time"
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// build version of the handler, it's set by the Makefile with -ldflags "-X main.version=..."
+var version = "dev"
+
+const STATUS_OK = "ok"
+const STATUS_UNAVAILABLE = "unavailable"
+
+type HealthResponse = types.HealthResponse
+type ConfigStatus = types.ConfigStatus
+type StoreStatus = types.StoreStatus
+
+// health checker of the handler, it's built by newHandler from apigw.Services
+type healthAPI struct{
+	Services *apigw.Services
+}
+
+// main AWS lambda function starting point.
+// It reports build version and configuration status, with ?deep=true the devices table is described too.
+// Response is 503 when anything is wrong, so monitors only need to check the status code. Anonymous callers only
+// get statuses, problems of configuration and the store are logged. Authenticated callers (see newHandler) get
+// names of settings and tables and errors of the store too, deep checks are only run for them.
+func (h *healthAPI) Health(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	principal := auth.FromContext(ctx)
+	health := HealthResponse{
+		Status:		STATUS_OK,
+		Version:	version,
+		Config:		h.configStatus(),
+	}
+	if health.Config.Status != STATUS_OK {
+		health.Status = STATUS_UNAVAILABLE
+	}
+
+	if request.QueryStringParameters["deep"] == "true" && principal != nil {
+		health.Store = h.storeStatus(ctx)
+		if health.Store.Status != STATUS_OK {
+			health.Status = STATUS_UNAVAILABLE
+		}
+	}
+
+	statusCode := 200
+	if health.Status != STATUS_OK {
+		fmt.Printf("Health check failed: config=%+v store=%+v request_id=%s\n", health.Config, health.Store, apigw.RequestID(ctx, request))
+		statusCode = 503
+	}
+	if principal == nil {
+		return apigw.JSONResponse(statusCode, withoutDetails(health)), nil
+	}
+	return apigw.JSONResponse(statusCode, &health), nil
+}
+
+// withoutDetails returns health as anonymous callers see it: statuses and latency, without names of settings and
+// tables or errors of the store
+func withoutDetails(health HealthResponse) *HealthResponse {
+	health.Config.Problems = nil
+	if health.Store != nil {
+		store := *health.Store
+		store.Table, store.Error = "", ""
+		health.Store = &store
+	}
+	return &health
+}
+
+// configStatus lists problems of configuration, a missing database session is a problem too
+func (h *healthAPI) configStatus() ConfigStatus {
+	problems := []string{}
+	if configError, ok := h.Services.ConfigError.(*config.Error); ok {
+		problems = append(problems, configError.Problems...)
+	} else if h.Services.ConfigError != nil {
+		problems = append(problems, h.Services.ConfigError.Error())
+	}
+	if h.Services.DynamoDB == nil && h.Services.ConfigError == nil {
+		problems = append(problems, "database session is not ready")
+	}
+
+	if len(problems) != 0 {
+		return ConfigStatus{Status: "invalid", Problems: problems}
+	}
+	return ConfigStatus{Status: STATUS_OK}
+}
+
+// storeStatus describes the devices table and measures how long it takes
+func (h *healthAPI) storeStatus(ctx context.Context) *StoreStatus {
+	tableName := h.Services.Config.DevicesTableName
+	if len(tableName) == 0 {
+		return &StoreStatus{Status: "unreachable", Error: "DEVICES_TABLE_NAME is not set"}
+	}
+	if h.Services.DynamoDB == nil {
+		return &StoreStatus{Status: "unreachable", Table: tableName, Error: "database session is not ready"}
+	}
+
+	// it isn't retried, health checks should see throttling as it is
+	start := time.Now()
+	output, err := h.Services.DynamoDB.DescribeTableWithContext(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(tableName)})
+	latency := time.Since(start).Nanoseconds() / int64(time.Millisecond)
+
+	if err != nil {
+		return &StoreStatus{Status: "unreachable", Table: tableName, LatencyMs: latency, Error: err.Error()}
+	}
+	// a table which is being created or deleted can't serve requests
+	if output.Table != nil && output.Table.TableStatus != nil && *output.Table.TableStatus != dynamodb.TableStatusActive && *output.Table.TableStatus != dynamodb.TableStatusUpdating {
+		return &StoreStatus{Status: "unreachable", Table: tableName, LatencyMs: latency, Error: "table status is " + *output.Table.TableStatus}
+	}
+	return &StoreStatus{Status: STATUS_OK, Table: tableName, LatencyMs: latency}
+}
+
+// newHandler wraps Health with the shared middlewares that don't need a valid configuration. The shallow check is
+// public and isn't rate limited. Deep checks call the store, so they and requests with credentials are authenticated
+// (devices:read scope) and rate limited like device endpoints, failed authentications of an ip are limited too.
+func newHandler(services *apigw.Services) apigw.Handler {
+	health := &healthAPI{Services: services}
+	authenticated := apigw.Chain(health.Health,
+		apigw.LimitFailedAuth(services.Limiter),
+		apigw.Authenticate(services.Keys, auth.SCOPE_DEVICES_READ),
+		apigw.RateLimit(services.Limiter),
+	)
+	route := func(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+		if request.QueryStringParameters["deep"] == "true" || hasCredentials(request) {
+			return authenticated(ctx, request)
+		}
+		return health.Health(ctx, request)
+	}
+
+	return apigw.Chain(route,
+		apigw.Logging(),
+		apigw.Trace(services.Tracer),
+		apigw.Measure(services.Metrics),
+		apigw.CORS(services.Config.CORSAllowedOrigin),
+		apigw.Recover(),
+		apigw.ErrorMapping(),
+		apigw.Deadline(services.Config.DeadlineMargin),
+	)
+}
+
+// hasCredentials reports whether request has an api key or is authorized by a bearer token
+func hasCredentials(request events.APIGatewayProxyRequest) bool {
+	return len(auth.GetHeader(request.Headers, auth.API_KEY_HEADER)) != 0 || request.RequestContext.Authorizer["authType"] == jwt.AUTH_TYPE_JWT
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("health")...)
+}
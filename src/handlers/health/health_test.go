@@ -0,0 +1,155 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"config"
+	"testing"
+	"context"
+	"errors"
+	"strings"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 					string
+	Request 				events.APIGatewayProxyRequest
+	Services 				*apigw.Services
+	ExpectedBody 			[]string // parts that body must contain
+	UnexpectedBody 			[]string // parts that body must not contain
+	ExpectedStatusCode 		int
+}
+
+// A fakeDynamoDB instance for mocking test that emulates real DynamoDB
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+// a mocked version of DynamoDB's DescribeTable function, only test_table_name exists
+func (fd *FakeDynamoDBAPI) DescribeTableWithContext(ctx aws.Context, input *dynamodb.DescribeTableInput, opts ...request.Option) (*dynamodb.DescribeTableOutput, error) {
+	if *input.TableName == "creating_table_name" {
+		return &dynamodb.DescribeTableOutput{Table: &dynamodb.TableDescription{TableStatus: aws.String(dynamodb.TableStatusCreating)}}, nil
+	}
+	if *input.TableName != "test_table_name" {
+		return nil, errors.New("ResourceNotFoundException: Requested resource not found")
+	}
+	return &dynamodb.DescribeTableOutput{Table: &dynamodb.TableDescription{TableStatus: aws.String(dynamodb.TableStatusActive)}}, nil
+}
+
+// a key without tenant and devices scopes, keys of apigwtest have devices:read
+const KEYS_ADMIN_API_KEY = "keysadminkey." + apigwtest.SECRET
+
+// services of tests, configError is what config.Load returned
+func newTestServices(tableName string, configError error) *apigw.Services {
+	keys := &apigwtest.FakeKeysDynamoDBAPI{Keys: []auth.ApiKey{{ID: "keysadminkey", Scopes: []string{auth.SCOPE_KEYS_ADMIN}}}}
+	services := apigwtest.NewServices(&FakeDynamoDBAPI{}, keys)
+	services.Config.DevicesTableName = tableName
+	services.ConfigError = configError
+	return services
+}
+
+func TestHealth(t *testing.T) {
+
+	deep := map[string]string{"deep": "true"}
+	withKey := map[string]string{"X-Api-Key": apigwtest.VIEWER_API_KEY}
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing shallow check **",
+			Request:			events.APIGatewayProxyRequest{},
+			Services:			newTestServices("test_table_name", nil),
+			ExpectedBody:		[]string{"\"status\": \"ok\"", "\"version\": \"dev\""},
+			UnexpectedBody:		[]string{"store"},
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing deep check **",
+			Request:			events.APIGatewayProxyRequest{QueryStringParameters: deep, Headers: withKey},
+			Services:			newTestServices("test_table_name", nil),
+			ExpectedBody:		[]string{"\"store\": {\n\t\t\"status\": \"ok\",\n\t\t\"table\": \"test_table_name\",\n\t\t\"latencyMs\""},
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing deep check without api key **",
+			Request:			events.APIGatewayProxyRequest{QueryStringParameters: deep},
+			Services:			newTestServices("test_table_name", nil),
+			ExpectedBody:		[]string{"No API key provided"},
+			ExpectedStatusCode:	401,
+		},
+		{
+			Name:				"** Testing deep check with a key without devices:read scope **",
+			Request:			events.APIGatewayProxyRequest{QueryStringParameters: deep, Headers: map[string]string{"X-Api-Key": KEYS_ADMIN_API_KEY}},
+			Services:			newTestServices("test_table_name", nil),
+			ExpectedBody:		[]string{"API key lacks required scope: devices:read"},
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name:				"** Testing unreachable table **",
+			Request:			events.APIGatewayProxyRequest{QueryStringParameters: deep, Headers: withKey},
+			Services:			newTestServices("missing_table_name", nil),
+			ExpectedBody:		[]string{"\"status\": \"unavailable\"", "\"status\": \"unreachable\"", "ResourceNotFoundException", "missing_table_name"},
+			ExpectedStatusCode:	503,
+		},
+		{
+			Name:				"** Testing table which is being created **",
+			Request:			events.APIGatewayProxyRequest{QueryStringParameters: deep, Headers: withKey},
+			Services:			newTestServices("creating_table_name", nil),
+			ExpectedBody:		[]string{"\"status\": \"unreachable\"", "table status is CREATING"},
+			ExpectedStatusCode:	503,
+		},
+		{
+			Name:				"** Testing missing table name **",
+			Request:			events.APIGatewayProxyRequest{},
+			Services:			newTestServices("", &config.Error{Problems: []string{"DEVICES_TABLE_NAME is not set"}}),
+			ExpectedBody:		[]string{"\"config\": {\n\t\t\"status\": \"invalid\"\n\t}"},
+			UnexpectedBody:		[]string{"DEVICES_TABLE_NAME", "problems"},
+			ExpectedStatusCode:	503,
+		},
+		{
+			Name:				"** Testing missing table name with api key **",
+			Request:			events.APIGatewayProxyRequest{Headers: withKey},
+			Services:			newTestServices("", &config.Error{Problems: []string{"DEVICES_TABLE_NAME is not set"}}),
+			ExpectedBody:		[]string{"\"problems\": [\n\t\t\t\"DEVICES_TABLE_NAME is not set\"\n\t\t]"},
+			UnexpectedBody:		[]string{"store"},
+			ExpectedStatusCode:	503,
+		},
+		{
+			Name:				"** Testing missing table name in deep check **",
+			Request:			events.APIGatewayProxyRequest{QueryStringParameters: deep, Headers: withKey},
+			Services:			newTestServices("", &config.Error{Problems: []string{"DEVICES_TABLE_NAME is not set"}}),
+			ExpectedBody:		[]string{"\"status\": \"unreachable\"", "\"error\": \"DEVICES_TABLE_NAME is not set\""},
+			ExpectedStatusCode:	503,
+		},
+	}
+
+	for _, test := range testCases {
+
+		response, _ := newHandler(test.Services)(context.Background(), test.Request)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("GET", "/health", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+		}
+		for _, part := range test.ExpectedBody {
+			if !strings.Contains(response.Body, part) {
+				t.Errorf("%s \n \t<expected body part: %s> <resulted body: %s>", test.Name, part, response.Body)
+			}
+		}
+		for _, part := range test.UnexpectedBody {
+			if strings.Contains(response.Body, part) {
+				t.Errorf("%s \n \t<unexpected body part: %s> <resulted body: %s>", test.Name, part, response.Body)
+			}
+		}
+	}
+} // end of TestHealth function
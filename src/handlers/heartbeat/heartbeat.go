@@ -0,0 +1,184 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"devices"
+	"policy"
+	"localserver"
+	"metrics"
+	"retry"
+	"schema"
+	"types"
+	"fmt"
+	"net"
+	"time"
+	"context"
+	"strings"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var ErrDeviceNotFound = errors.New("device not found")
+
+// reasons of rejected inputs, they are Reason dimension of ValidationFailures metric
+const REASON_INVALID_JSON = "invalid_json"
+const REASON_SCHEMA_VIOLATION = "schema_violation"
+
+type SuccessResponse = types.HeartbeatResponse
+
+// devices table of the handler, it's built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Now func() time.Time
+}
+
+// main AWS lambda function starting point.
+// It records that a device is online: lastSeenAt is set to now, firmwareVersion and ipAddress are set when they are sent.
+// Devices send it often, so it only sets those attributes and other fields of the device aren't read or written.
+func (ig *dynamoDBAPI) Heartbeat(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:write scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+
+	if denied := policy.Check(principal, policy.PERMISSION_DEVICES_HEARTBEAT); denied != nil {
+		return *denied, nil
+	}
+
+	id := request.PathParameters["id"]
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "No ID Field Provided"),
+			StatusCode: 404,
+		}, nil
+	}
+
+	heartbeat, reason, err := validateInputs(request)
+	if err != nil {
+		metrics.ValidationFailure(ctx, reason)
+		return events.APIGatewayProxyResponse{
+			Body:	err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	lastSeenAt := ig.Now().UTC().Format(time.RFC3339)
+	err = ig.updateItemInDatabase(ctx, principal.TenantID, id, lastSeenAt, heartbeat)
+	if err == ErrDeviceNotFound {
+		devices.LogCrossTenantAccess(ctx, ig.DynamoDB, ig.TableName, principal, id)
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Desired device with provided id was not founded"),
+			StatusCode: 404,
+		}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	successResponseJson, _ := json.MarshalIndent(&SuccessResponse{Status: "heartbeat recorded", LastSeenAt: lastSeenAt}, "", "\t")
+	return events.APIGatewayProxyResponse{
+		Body:	string(successResponseJson),
+		StatusCode: 200,
+	}, nil
+}
+
+// validateInputs returns the reported firmware version and ip address, or reason and error body of rejecting them.
+// body is optional, when it's sent it's validated against the heartbeat schema (GET /schemas/heartbeat.json).
+func validateInputs(request events.APIGatewayProxyRequest) (types.HeartbeatRequest, string, error) {
+	heartbeat := types.HeartbeatRequest{}
+	if len(strings.TrimSpace(request.Body)) == 0 {
+		return heartbeat, "", nil
+	}
+
+	var body interface{}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return heartbeat, REASON_INVALID_JSON, errors.New(createErrorResponseJson(400, "Wrong format: Inputs must be a valid json."))
+	}
+
+	violations := api.ValidateHeartbeat(body)
+	json.Unmarshal([]byte(request.Body), &heartbeat)
+	if len(violations) == 0 && len(heartbeat.IPAddress) != 0 && net.ParseIP(heartbeat.IPAddress) == nil {
+		violations = append(violations, schema.Violation{Pointer: "/ipAddress", Message: "must be an IPv4 or IPv6 address"})
+	}
+
+	if len(violations) != 0 {
+		errorMessage := "Heartbeat doesn't match its schema " + api.SCHEMAS_PATH + "heartbeat.json"
+		return types.HeartbeatRequest{}, REASON_SCHEMA_VIOLATION, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
+	}
+	return heartbeat, "", nil
+}
+
+func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
+	return types.NewErrorResponseJson(errorCode, errorMessage)
+}
+
+// function that sets heartbeat attributes of an existing device of the tenant. It's a single UpdateItem that doesn't
+// return the device, so a heartbeat costs one write and nothing else of the device is changed.
+func (ig *dynamoDBAPI) updateItemInDatabase(ctx context.Context, tenantId string, id string, lastSeenAt string, heartbeat types.HeartbeatRequest) error {
+
+	names := map[string]*string{"#lastSeenAt": aws.String("lastSeenAt")}
+	values := map[string]*dynamodb.AttributeValue{":lastSeenAt": {S: aws.String(lastSeenAt)}}
+	assignments := []string{"#lastSeenAt = :lastSeenAt"}
+	if len(heartbeat.FirmwareVersion) != 0 {
+		names["#firmwareVersion"] = aws.String("firmwareVersion")
+		values[":firmwareVersion"] = &dynamodb.AttributeValue{S: aws.String(heartbeat.FirmwareVersion)}
+		assignments = append(assignments, "#firmwareVersion = :firmwareVersion")
+	}
+	if len(heartbeat.IPAddress) != 0 {
+		names["#ipAddress"] = aws.String("ipAddress")
+		values[":ipAddress"] = &dynamodb.AttributeValue{S: aws.String(heartbeat.IPAddress)}
+		assignments = append(assignments, "#ipAddress = :ipAddress")
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: ig.TableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		UpdateExpression: aws.String("SET " + strings.Join(assignments, ", ")),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: values,
+		ReturnValues: aws.String(dynamodb.ReturnValueNone),
+	}
+
+	err := ig.Retry.Do(ctx, func() error {
+		_, err := ig.DynamoDB.UpdateItemWithContext(ctx, input)
+		return err
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return ErrDeviceNotFound
+	}
+	return err
+}
+
+// newHandler wraps Heartbeat with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services.
+func newHandler(services *apigw.Services) apigw.Handler {
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Now: time.Now}
+	return apigw.Chain(table.Heartbeat, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("heartbeat")...)
+}
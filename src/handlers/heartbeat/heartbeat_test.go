@@ -0,0 +1,152 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"retry"
+	"schema"
+	"types"
+	"testing"
+	"context"
+	"time"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+	ExpectedUpdate 				string
+}
+
+// A fakeDynamoDB instance for mocking test that emulates real DynamoDB, only "id_test" of "tenant_test" exists.
+// it keeps UpdateExpression of the last update
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	Update	string
+}
+
+// a mocked version of DynamoDB's Query function on the id index, it's used for logging cross-tenant access
+// attempts and devices of other tenants aren't known
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{}, nil
+}
+
+// a mocked version of DynamoDB's UpdateItem function
+func (fd *FakeDynamoDBAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	id := *input.Key["id"].S
+	if id == "id_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	if *input.Key["tenantId"].S != "tenant_test" || id != "id_test" {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+
+	fd.Update = *input.UpdateExpression
+	for name, value := range input.ExpressionAttributeValues {
+		fd.Update += " " + name + "=" + *value.S
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func TestHeartbeat(t *testing.T) {
+
+	heartbeat := func(id string, body string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": id}, Body: body}
+	}
+	recorded := "{\n\t\"status\": \"heartbeat recorded\",\n\t\"lastSeenAt\": \"2018-06-26T08:00:00Z\"\n}"
+	heartbeatMessage := "Heartbeat doesn't match its schema /schemas/heartbeat.json"
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing viewer can't send heartbeats **",
+			InputRequest:		events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.VIEWER_API_KEY}, PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 403,\n\t\t\"message\": \"Operation is not permitted: none of roles [viewer] grants devices:heartbeat\"\n\t}\n}",
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name:				"** Testing heartbeat without body **",
+			InputRequest:		heartbeat("id_test", ""),
+			ExpectedBody:		recorded,
+			ExpectedStatusCode:	200,
+			ExpectedUpdate:		"SET #lastSeenAt = :lastSeenAt :lastSeenAt=2018-06-26T08:00:00Z",
+		},
+		{
+			Name:				"** Testing heartbeat with firmware version and ip address **",
+			InputRequest:		heartbeat("id_test", "{\"firmwareVersion\": \"2.1.0\", \"ipAddress\": \"2001:db8::1\"}"),
+			ExpectedBody:		recorded,
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing invalid ip address **",
+			InputRequest:		heartbeat("id_test", "{\"ipAddress\": \"10.0.0.300\"}"),
+			ExpectedBody:		types.NewViolationsResponseJson(400, heartbeatMessage, []schema.Violation{{Pointer: "/ipAddress", Message: "must be an IPv4 or IPv6 address"}}),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing unknown and empty fields **",
+			InputRequest:		heartbeat("id_test", "{\"firmwareVersion\": \"\", \"name\": \"renamed\"}"),
+			ExpectedBody:		types.NewViolationsResponseJson(400, heartbeatMessage, []schema.Violation{{Pointer: "/firmwareVersion", Message: "must not be empty"}, {Pointer: "/name", Message: "is not allowed"}}),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing wrong json format **",
+			InputRequest:		heartbeat("id_test", "{{{}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Wrong format: Inputs must be a valid json.\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing missing device **",
+			InputRequest:		heartbeat("id_missing", ""),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Desired device with provided id was not founded\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		heartbeat("id_error", ""),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}",
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases, heartbeats are recorded at a fixed time
+		devices := &FakeDynamoDBAPI{}
+		services := apigwtest.NewServices(devices, nil)
+		heartbeats := &dynamoDBAPI{DynamoDB: devices, TableName: aws.String("test_table_name"), Retry: retry.Default, Now: func() time.Time { return time.Unix(1530000000, 0) }}
+		handler := apigw.Chain(heartbeats.Heartbeat, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+
+		// requests without explicit headers are sent with an operator key
+		if test.InputRequest.Headers == nil {
+			test.InputRequest.Headers = map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}
+		}
+
+		// calls heartbeat.go's Heartbeat function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("POST", "/devices/{id}/heartbeat", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+
+		if len(test.ExpectedUpdate) != 0 && devices.Update != test.ExpectedUpdate {
+			t.Errorf("%s \n \t<expected update: %s> <resulted update: %s>", test.Name, test.ExpectedUpdate, devices.Update)
+		}
+	}
+
+} // end of TestHeartbeat function
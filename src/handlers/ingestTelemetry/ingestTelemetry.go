@@ -0,0 +1,180 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"devices"
+	"policy"
+	"localserver"
+	"metrics"
+	"retry"
+	"schema"
+	"telemetry"
+	"types"
+	"fmt"
+	"time"
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// reasons of rejected inputs, they are Reason dimension of ValidationFailures metric
+const REASON_INVALID_JSON = "invalid_json"
+const REASON_SCHEMA_VIOLATION = "schema_violation"
+
+// how far in the future a reading can be, clocks of devices are not exact
+const MAX_CLOCK_SKEW = 5 * time.Minute
+
+type SuccessResponse = types.TelemetryIngestResponse
+
+// devices table and telemetry store of the handler, they are built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Now func() time.Time
+	Telemetry *telemetry.Store
+}
+
+// main AWS lambda function starting point.
+// It stores a batch of readings of a device of caller's tenant, readings of unknown devices are rejected.
+// Readings must be measured within the retention of the telemetry table and not in the future. Sending a batch
+// again is safe, so a batch that is partly written is answered with 503 and the client retries it as a whole.
+func (ig *dynamoDBAPI) IngestTelemetry(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:write scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+
+	if denied := policy.Check(principal, policy.PERMISSION_TELEMETRY_WRITE); denied != nil {
+		return *denied, nil
+	}
+
+	id := request.PathParameters["id"]
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "No ID Field Provided"),
+			StatusCode: 404,
+		}, nil
+	}
+
+	readings, reason, err := validateInputs(request, ig.Now(), ig.Telemetry.Retention)
+	if err != nil {
+		metrics.ValidationFailure(ctx, reason)
+		return events.APIGatewayProxyResponse{
+			Body:	err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	exists, err := devices.Exists(ctx, ig.DynamoDB, ig.TableName, ig.Retry, principal.TenantID, id)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	if !exists {
+		devices.LogCrossTenantAccess(ctx, ig.DynamoDB, ig.TableName, principal, id)
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Desired device with provided id was not founded"),
+			StatusCode: 404,
+		}, nil
+	}
+
+	written, err := ig.Telemetry.Put(ctx, principal.TenantID, id, readings)
+	if err != nil && written == 0 {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	if err != nil {
+		// readings of the same time replace each other, so the client sends the whole batch again
+		fmt.Printf("%d of %d readings of device %s are written: %s\n", written, len(readings), id, err.Error())
+		response := apigw.ErrorResponse(503, fmt.Sprintf("Only %d of %d readings are recorded, please send the batch again", written, len(readings)))
+		apigw.SetHeader(&response, "Retry-After", apigw.RETRY_AFTER_SECONDS)
+		return response, nil
+	}
+
+	successResponseJson, _ := json.MarshalIndent(&SuccessResponse{Status: "telemetry recorded", Accepted: len(readings)}, "", "\t")
+	return events.APIGatewayProxyResponse{
+		Body:	string(successResponseJson),
+		StatusCode: 200,
+	}, nil
+}
+
+// validateInputs returns readings of the body, or reason and error body of rejecting them. The body is validated
+// against the telemetry schema (GET /schemas/telemetry.json), then times of readings are checked against now:
+// they can't be older than retention (they would expire at once) or in the future, and every time must be unique
+// in the batch because a reading of the same millisecond replaces the other.
+func validateInputs(request events.APIGatewayProxyRequest, now time.Time, retention time.Duration) ([]telemetry.Reading, string, error) {
+	var body interface{}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return nil, REASON_INVALID_JSON, errors.New(createErrorResponseJson(400, "Wrong format: Inputs must be a valid json."))
+	}
+
+	readings := []telemetry.Reading{}
+	violations := api.ValidateTelemetry(body)
+	if len(violations) == 0 {
+		telemetryRequest := types.TelemetryRequest{}
+		json.Unmarshal([]byte(request.Body), &telemetryRequest)
+		if len(telemetryRequest.Readings) == 0 {
+			violations = append(violations, schema.Violation{Pointer: "/readings", Message: "must not be empty"})
+		}
+
+		seen := map[int64]int{}
+		for i, reading := range telemetryRequest.Readings {
+			pointer := fmt.Sprintf("/readings/%d", i)
+			if len(reading.Values) == 0 {
+				violations = append(violations, schema.Violation{Pointer: pointer + "/values", Message: "must not be empty"})
+			}
+
+			at, err := time.Parse(time.RFC3339Nano, reading.At)
+			milliseconds := at.UnixNano() / int64(time.Millisecond)
+			previous, duplicate := seen[milliseconds]
+			switch {
+			case err != nil:
+				violations = append(violations, schema.Violation{Pointer: pointer + "/at", Message: "must be a date-time like 2018-06-26T08:00:00Z"})
+			case at.Before(now.Add(-retention)):
+				violations = append(violations, schema.Violation{Pointer: pointer + "/at", Message: "must not be older than the retention of " + retention.String()})
+			case at.After(now.Add(MAX_CLOCK_SKEW)):
+				violations = append(violations, schema.Violation{Pointer: pointer + "/at", Message: "must not be in the future"})
+			case duplicate:
+				violations = append(violations, schema.Violation{Pointer: pointer + "/at", Message: fmt.Sprintf("must not be the same time as /readings/%d/at", previous)})
+			default:
+				seen[milliseconds] = i
+				readings = append(readings, telemetry.Reading{At: at, Values: reading.Values})
+			}
+		}
+	}
+
+	if len(violations) != 0 {
+		errorMessage := "Telemetry doesn't match its schema " + api.SCHEMAS_PATH + "telemetry.json"
+		return nil, REASON_SCHEMA_VIOLATION, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
+	}
+	return readings, "", nil
+}
+
+func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
+	return types.NewErrorResponseJson(errorCode, errorMessage)
+}
+
+// newHandler wraps IngestTelemetry with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services. The telemetry table is only needed by telemetry handlers, so it's checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	if len(services.Config.TelemetryTableName) == 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: []string{"TELEMETRY_TABLE_NAME is not set"}}
+	}
+	store := &telemetry.Store{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.TelemetryTableName), Retry: services.Retry, Retention: services.Config.TelemetryRetention}
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Now: time.Now, Telemetry: store}
+	return apigw.Chain(table.IngestTelemetry, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("ingestTelemetry")...)
+}
@@ -0,0 +1,231 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"retry"
+	"schema"
+	"telemetry"
+	"types"
+	"testing"
+	"context"
+	"strings"
+	"time"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+	ExpectedWrites 				int
+}
+
+// A fakeDynamoDB instance for mocking test that emulates real DynamoDB, only "id_test" of "tenant_test" exists
+// in devices table. It counts readings that are written to telemetry table, a batch with a reading of 06:00 fails.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	Writes	int
+}
+
+// a mocked version of DynamoDB's Query function on the id index, it's used for logging cross-tenant access
+// attempts and devices of other tenants aren't known
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{}, nil
+}
+
+// a mocked version of DynamoDB's GetItem function
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	id := *input.Key["id"].S
+	if id == "id_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	output := new(dynamodb.GetItemOutput)
+	if *input.Key["tenantId"].S == "tenant_test" && id == "id_test" {
+		output.SetItem(map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}})
+	}
+	return output, nil
+}
+
+// a mocked version of DynamoDB's BatchWriteItem function
+func (fd *FakeDynamoDBAPI) BatchWriteItemWithContext(ctx aws.Context, input *dynamodb.BatchWriteItemInput, opts ...request.Option) (*dynamodb.BatchWriteItemOutput, error) {
+	for _, writeRequest := range input.RequestItems["test_telemetry_table_name"] {
+		if *writeRequest.PutRequest.Item["at"].N == "1529992800000" {
+			return nil, errors.New("Unexpected Error has occured")
+		}
+	}
+	for _, writeRequest := range input.RequestItems["test_telemetry_table_name"] {
+		if *writeRequest.PutRequest.Item["device"].S != "tenant_test#id_test" {
+			return nil, errors.New("Readings are written to another device")
+		}
+		fd.Writes++
+	}
+	return &dynamodb.BatchWriteItemOutput{}, nil
+}
+
+func TestIngestTelemetry(t *testing.T) {
+
+	ingest := func(id string, readings ...string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": id}, Body: "{\"readings\": [" + strings.Join(readings, ", ") + "]}"}
+	}
+	reading := func(at string) string {
+		return "{\"at\": \"" + at + "\", \"values\": {\"temperature\": 21.5, \"humidity\": 40}}"
+	}
+	telemetryMessage := "Telemetry doesn't match its schema /schemas/telemetry.json"
+	atViolation := func(pointer string, message string) string {
+		return types.NewViolationsResponseJson(400, telemetryMessage, []schema.Violation{{Pointer: pointer, Message: message}})
+	}
+
+	// 30 readings are written in two batches
+	batch := []string{}
+	for i := 0; i < 30; i++ {
+		batch = append(batch, reading(time.Unix(1530000000 - int64(i), 0).UTC().Format(time.RFC3339)))
+	}
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing viewer can't send telemetry **",
+			InputRequest:		events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.VIEWER_API_KEY}, PathParameters: map[string]string{"id": "id_test"}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 403,\n\t\t\"message\": \"Operation is not permitted: none of roles [viewer] grants telemetry:write\"\n\t}\n}",
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name:				"** Testing a batch of readings **",
+			InputRequest:		ingest("id_test", batch...),
+			ExpectedBody:		"{\n\t\"status\": \"telemetry recorded\",\n\t\"accepted\": 30\n}",
+			ExpectedStatusCode:	200,
+			ExpectedWrites:		30,
+		},
+		{
+			Name:				"** Testing a batch that is partly written **",
+			InputRequest:		ingest("id_test", append(batch[:25:25], reading("2018-06-26T06:00:00Z"))...),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 503,\n\t\t\"message\": \"Only 25 of 26 readings are recorded, please send the batch again\"\n\t}\n}",
+			ExpectedStatusCode:	503,
+			ExpectedWrites:		25,
+		},
+		{
+			Name:				"** Testing readings with fractions of seconds and offsets **",
+			InputRequest:		ingest("id_test", reading("2018-06-26T07:59:59.250Z"), reading("2018-06-26T09:59:59.5+02:00")),
+			ExpectedBody:		"{\n\t\"status\": \"telemetry recorded\",\n\t\"accepted\": 2\n}",
+			ExpectedStatusCode:	200,
+			ExpectedWrites:		2,
+		},
+		{
+			Name:				"** Testing invalid time **",
+			InputRequest:		ingest("id_test", reading("yesterday")),
+			ExpectedBody:		atViolation("/readings/0/at", "must be a date-time like 2018-06-26T08:00:00Z"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing readings older than retention **",
+			InputRequest:		ingest("id_test", reading("2018-05-01T00:00:00Z")),
+			ExpectedBody:		atViolation("/readings/0/at", "must not be older than the retention of 720h0m0s"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing readings in the future **",
+			InputRequest:		ingest("id_test", reading("2018-06-26T08:05:00Z"), reading("2018-06-26T08:05:01Z")),
+			ExpectedBody:		atViolation("/readings/1/at", "must not be in the future"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing readings of the same time **",
+			InputRequest:		ingest("id_test", reading("2018-06-26T07:00:00Z"), reading("2018-06-26T09:00:00+02:00")),
+			ExpectedBody:		atViolation("/readings/1/at", "must not be the same time as /readings/0/at"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing schema violations **",
+			InputRequest:		ingest("id_test", "{\"at\": \"2018-06-26T07:00:00Z\", \"values\": {\"wind speed\": \"3\"}}"),
+			ExpectedBody:		types.NewViolationsResponseJson(400, telemetryMessage, []schema.Violation{
+				{Pointer: "/readings/0/values/wind speed", Message: "name must match pattern ^[A-Za-z0-9_.-]+$"},
+				{Pointer: "/readings/0/values/wind speed", Message: "must be number"},
+			}),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing null readings **",
+			InputRequest:		events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"readings\": null}"},
+			ExpectedBody:		atViolation("/readings", "must be array"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing null values **",
+			InputRequest:		ingest("id_test", "{\"at\": \"2018-06-26T07:00:00Z\", \"values\": null}"),
+			ExpectedBody:		atViolation("/readings/0/values", "must be object"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing wrong json format **",
+			InputRequest:		events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{{{}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Wrong format: Inputs must be a valid json.\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing unknown device **",
+			InputRequest:		ingest("id_missing", reading("2018-06-26T07:00:00Z")),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Desired device with provided id was not founded\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		ingest("id_error", reading("2018-06-26T07:00:00Z")),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}",
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases, readings are checked against a fixed time
+		fake := &FakeDynamoDBAPI{}
+		services := apigwtest.NewServices(fake, nil)
+		store := &telemetry.Store{DynamoDB: fake, TableName: aws.String("test_telemetry_table_name"), Retry: retry.Default, Retention: services.Config.TelemetryRetention}
+		devices := &dynamoDBAPI{DynamoDB: fake, TableName: aws.String("test_table_name"), Retry: retry.Default, Now: func() time.Time { return time.Unix(1530000000, 0) }, Telemetry: store}
+		handler := apigw.Chain(devices.IngestTelemetry, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+
+		// requests without explicit headers are sent with an operator key
+		if test.InputRequest.Headers == nil {
+			test.InputRequest.Headers = map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}
+		}
+
+		// calls ingestTelemetry.go's IngestTelemetry function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("POST", "/devices/{id}/telemetry", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+
+		if fake.Writes != test.ExpectedWrites {
+			t.Errorf("%s \n \t<expected writes: %d> <resulted writes: %d>", test.Name, test.ExpectedWrites, fake.Writes)
+		}
+	}
+
+} // end of TestIngestTelemetry function
+
+func TestMissingTelemetryTable(t *testing.T) {
+
+	// as TELEMETRY_TABLE_NAME is not set, handler must not touch the database
+	services := apigwtest.NewServices(&FakeDynamoDBAPI{}, nil)
+	services.Config.TelemetryTableName = ""
+	handler := newHandler(services)
+
+	response, _ := handler(context.Background(), events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}, PathParameters: map[string]string{"id": "id_test"}})
+	if response.StatusCode != 500 {
+		t.Errorf("** Testing missing telemetry table ** \n \t<expected error-code: 500> <resulted error-code: %d>", response.StatusCode)
+	}
+} // end of TestMissingTelemetryTable function
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"commands"
+	"config"
+	"devices"
+	"policy"
+	"localserver"
+	"retry"
+	"types"
+	"fmt"
+	"time"
+	"context"
+	"strconv"
+	"encoding/base64"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// commands of a page when ?limit= isn't sent, and the most that can be asked for
+const DEFAULT_LIMIT = 50
+const MAX_LIMIT = 100
+
+type SuccessResponse = types.CommandListResponse
+
+// devices table and commands store of the handler, they are built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Now func() time.Time
+	Commands *commands.Store
+}
+
+// main AWS lambda function starting point.
+// It returns a page of commands of a device of caller's tenant, newest first. Devices poll with ?pending: only queued
+// and delivered commands that aren't expired are returned, oldest first, and queued ones become delivered. Delivered
+// commands are returned again until they are acked, so a device that fails before acking gets them once more.
+// Commands that are found expired are stored as expired. nextToken of the response is sent back as ?nextToken=.
+func (ig *dynamoDBAPI) ListCommands(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:read scope get here (see newHandler), and they only see devices of their own tenant
+	principal := auth.FromContext(ctx)
+
+	pending, err := parsePending(request.QueryStringParameters)
+	if err != nil {
+		return apigw.ErrorResponse(400, err.Error()), nil
+	}
+
+	// polling delivers commands, so it's only allowed to devices that receive them
+	permission := policy.PERMISSION_COMMANDS_READ
+	if pending {
+		permission = policy.PERMISSION_COMMANDS_RECEIVE
+	}
+	if denied := policy.Check(principal, permission); denied != nil {
+		return *denied, nil
+	}
+
+	id := request.PathParameters["id"]
+	if id == "" {
+		return apigw.ErrorResponse(404, "No ID Field Provided"), nil
+	}
+	limit, err := parseLimit(request.QueryStringParameters["limit"])
+	if err != nil {
+		return apigw.ErrorResponse(400, err.Error()), nil
+	}
+	startId, err := decodeToken(request.QueryStringParameters["nextToken"])
+	if err != nil {
+		return apigw.ErrorResponse(400, err.Error()), nil
+	}
+
+	exists, err := devices.Exists(ctx, ig.DynamoDB, ig.TableName, ig.Retry, principal.TenantID, id)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	if !exists {
+		devices.LogCrossTenantAccess(ctx, ig.DynamoDB, ig.TableName, principal, id)
+		return apigw.ErrorResponse(404, "Desired device with provided id was not founded"), nil
+	}
+
+	stored, lastId, err := ig.Commands.List(ctx, principal.TenantID, id, pending, limit, startId)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	now := ig.Now().UTC()
+	result := []types.Command{}
+	for _, command := range stored {
+		command, err = ig.Commands.Settle(ctx, principal.TenantID, id, command, now)
+		if err == nil && pending && command.Status == types.COMMAND_QUEUED {
+			command, err = ig.deliver(ctx, principal.TenantID, id, command, now)
+		}
+		if err == commands.ErrCommandNotFound {
+			continue
+		}
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+		if !pending || commands.IsPending(command, now) {
+			result = append(result, command)
+		}
+	}
+
+	return apigw.JSONResponse(200, &SuccessResponse{Commands: result, NextToken: encodeToken(lastId)}), nil
+}
+
+// deliver marks a queued command as delivered at now. When it's changed concurrently (e.g. another poll delivered it)
+// the stored command is returned.
+func (ig *dynamoDBAPI) deliver(ctx context.Context, tenantId string, id string, command types.Command, now time.Time) (types.Command, error) {
+	delivered := command
+	delivered.Status = types.COMMAND_DELIVERED
+	delivered.DeliveredAt = now.Format(time.RFC3339)
+	delivered, err := ig.Commands.Transition(ctx, tenantId, id, delivered, now, types.COMMAND_QUEUED)
+	if err == commands.ErrStatusChanged {
+		return ig.Commands.Get(ctx, tenantId, id, command.ID)
+	}
+	return delivered, err
+}
+
+// parsePending parses ?pending, it's true when it's sent without a value
+func parsePending(query map[string]string) (bool, error) {
+	value, ok := query["pending"]
+	switch {
+	case !ok || value == "false":
+		return false, nil
+	case value == "" || value == "true":
+		return true, nil
+	}
+	return false, fmt.Errorf("pending must be true or false: %s", value)
+}
+
+func parseLimit(value string) (int, error) {
+	if len(value) == 0 {
+		return DEFAULT_LIMIT, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > MAX_LIMIT {
+		return 0, fmt.Errorf("limit must be an integer from 1 to %d: %s", MAX_LIMIT, value)
+	}
+	return limit, nil
+}
+
+// nextToken is the id of the last returned command, tenant and device always come from the request
+func encodeToken(id string) string {
+	if len(id) == 0 {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+func decodeToken(token string) (string, error) {
+	id, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("nextToken is not valid")
+	}
+	return string(id), nil
+}
+
+// newHandler wraps ListCommands with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services. The commands table is only needed by command handlers, so it's checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	if len(services.Config.CommandsTableName) == 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: []string{"COMMANDS_TABLE_NAME is not set"}}
+	}
+	store := &commands.Store{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.CommandsTableName), Retry: services.Retry}
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Now: time.Now, Commands: store}
+	return apigw.Chain(table.ListCommands, apigw.Standard(services, auth.SCOPE_DEVICES_READ)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("listCommands")...)
+}
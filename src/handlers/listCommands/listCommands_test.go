@@ -0,0 +1,252 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"commands"
+	"retry"
+	"types"
+	"testing"
+	"context"
+	"time"
+	"sort"
+	"errors"
+	"strings"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+	ExpectedCommands 			string // "id:status" of returned commands and nextToken of a successful response
+	ExpectedStored 				string // "id:status" of stored commands after the request
+}
+
+// A fakeDynamoDB instance for mocking test that emulates real DynamoDB with device "id_test" of "tenant_test" and its
+// commands, in the order they are queued: "c1" succeeded, "c2" delivered but expired at 07:30, "c3" delivered and
+// "c4" queued that expire at 09:00. Its UpdateItem checks conditions of commands.Store.Transition.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	commands	map[string]map[string]*dynamodb.AttributeValue
+}
+
+func newFakeDynamoDBAPI() *FakeDynamoDBAPI {
+	fd := &FakeDynamoDBAPI{commands: map[string]map[string]*dynamodb.AttributeValue{}}
+	stored := []types.Command{
+		{ID: "c1", Name: "reboot", Status: types.COMMAND_SUCCEEDED, CreatedAt: "2018-06-26T06:00:00Z", ExpiresAt: "2018-06-26T07:00:00Z", DeliveredAt: "2018-06-26T06:01:00Z", CompletedAt: "2018-06-26T06:02:00Z"},
+		{ID: "c2", Name: "recalibrate", Status: types.COMMAND_DELIVERED, CreatedAt: "2018-06-26T06:30:00Z", ExpiresAt: "2018-06-26T07:30:00Z", DeliveredAt: "2018-06-26T06:31:00Z"},
+		{ID: "c3", Name: "reboot", Status: types.COMMAND_DELIVERED, CreatedAt: "2018-06-26T07:00:00Z", ExpiresAt: "2018-06-26T09:00:00Z", DeliveredAt: "2018-06-26T07:01:00Z"},
+		{ID: "c4", Name: "reboot", Status: types.COMMAND_QUEUED, CreatedAt: "2018-06-26T07:30:00Z", ExpiresAt: "2018-06-26T09:00:00Z"},
+	}
+	for _, command := range stored {
+		item, _ := dynamodbattribute.MarshalMap(command)
+		item["device"] = &dynamodb.AttributeValue{S: aws.String(commands.DeviceKey("tenant_test", "id_test"))}
+		fd.commands[command.ID] = item
+	}
+	return fd
+}
+
+// a mocked version of DynamoDB's GetItem function, for both of devices and commands tables
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	output := new(dynamodb.GetItemOutput)
+	id := *input.Key["id"].S
+	if id == "id_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+
+	if *input.TableName == apigwtest.COMMANDS_TABLE_NAME {
+		output.SetItem(fd.commands[id])
+	} else if *input.Key["tenantId"].S == "tenant_test" && id == "id_test" {
+		output.SetItem(map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}})
+	}
+	return output, nil
+}
+
+// a mocked version of DynamoDB's Query function, it applies order, start key, limit and the filter of pending commands
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	if input.IndexName != nil && *input.IndexName == types.DEVICES_ID_INDEX {
+		// cross-tenant access attempts are logged, devices of other tenants aren't known
+		return &dynamodb.QueryOutput{}, nil
+	}
+	ids := []string{}
+	for id := range fd.commands {
+		ids = append(ids, id)
+	}
+	forward := aws.BoolValue(input.ScanIndexForward)
+	sort.Slice(ids, func(i, j int) bool { return (ids[i] < ids[j]) == forward })
+
+	output := &dynamodb.QueryOutput{}
+	evaluated := []string{}
+	for _, id := range ids {
+		if start := input.ExclusiveStartKey; start != nil && (id == *start["id"].S || (id < *start["id"].S) == forward) {
+			continue
+		}
+		if int64(len(evaluated)) == aws.Int64Value(input.Limit) {
+			output.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{"device": input.ExpressionAttributeValues[":device"], "id": {S: aws.String(evaluated[len(evaluated) - 1])}}
+			break
+		}
+		evaluated = append(evaluated, id)
+		status := *fd.commands[id]["status"].S
+		if input.FilterExpression != nil && status != types.COMMAND_QUEUED && status != types.COMMAND_DELIVERED {
+			continue
+		}
+		output.Items = append(output.Items, fd.commands[id])
+	}
+	return output, nil
+}
+
+// a mocked version of DynamoDB's UpdateItem function, it checks the allowed statuses and the expiry of the condition
+func (fd *FakeDynamoDBAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	failed := awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	item, ok := fd.commands[*input.Key["id"].S]
+	if !ok {
+		return nil, failed
+	}
+
+	allowed := false
+	for name, value := range input.ExpressionAttributeValues {
+		if strings.HasPrefix(name, ":from") && *value.S == *item["status"].S {
+			allowed = true
+		}
+	}
+	due := *item["expiresAt"].S <= *input.ExpressionAttributeValues[":now"].S
+	if !allowed || due != strings.Contains(*input.ConditionExpression, "<=") {
+		return nil, failed
+	}
+
+	for _, name := range []string{"status", "deliveredAt", "completedAt", "result"} {
+		if value, ok := input.ExpressionAttributeValues[":" + name]; ok {
+			item[name] = value
+		}
+	}
+	return &dynamodb.UpdateItemOutput{Attributes: item}, nil
+}
+
+func TestListCommands(t *testing.T) {
+
+	list := func(key string, id string, query map[string]string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": key}, PathParameters: map[string]string{"id": id}, QueryStringParameters: query}
+	}
+	errorBody := func(code int, message string) string {
+		return types.NewErrorResponseJson(code, message)
+	}
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing listing every command **",
+			InputRequest:		list(apigwtest.VIEWER_API_KEY, "id_test", nil),
+			ExpectedStatusCode:	200,
+			ExpectedCommands:	"c4:queued c3:delivered c2:expired c1:succeeded",
+			ExpectedStored:		"c1:succeeded c2:expired c3:delivered c4:queued",
+		},
+		{
+			Name:				"** Testing polling pending commands **",
+			InputRequest:		list(apigwtest.DEVICE_API_KEY, "id_test", map[string]string{"pending": ""}),
+			ExpectedStatusCode:	200,
+			ExpectedCommands:	"c3:delivered c4:delivered",
+			ExpectedStored:		"c1:succeeded c2:expired c3:delivered c4:delivered",
+		},
+		{
+			Name:				"** Testing a page of commands **",
+			InputRequest:		list(apigwtest.VIEWER_API_KEY, "id_test", map[string]string{"limit": "1"}),
+			ExpectedStatusCode:	200,
+			ExpectedCommands:	"c4:queued nextToken=YzQ",
+			ExpectedStored:		"c1:succeeded c2:delivered c3:delivered c4:queued",
+		},
+		{
+			Name:				"** Testing the next page of commands **",
+			InputRequest:		list(apigwtest.VIEWER_API_KEY, "id_test", map[string]string{"limit": "2", "nextToken": "YzQ"}),
+			ExpectedStatusCode:	200,
+			ExpectedCommands:	"c3:delivered c2:expired nextToken=YzI",
+			ExpectedStored:		"c1:succeeded c2:expired c3:delivered c4:queued",
+		},
+		{
+			Name:				"** Testing viewer polling commands **",
+			InputRequest:		list(apigwtest.VIEWER_API_KEY, "id_test", map[string]string{"pending": "true"}),
+			ExpectedBody:		errorBody(403, "Operation is not permitted: none of roles [viewer] grants commands:receive"),
+			ExpectedStatusCode:	403,
+			ExpectedStored:		"c1:succeeded c2:delivered c3:delivered c4:queued",
+		},
+		{
+			Name:				"** Testing invalid pending **",
+			InputRequest:		list(apigwtest.DEVICE_API_KEY, "id_test", map[string]string{"pending": "yes"}),
+			ExpectedBody:		errorBody(400, "pending must be true or false: yes"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing invalid limit **",
+			InputRequest:		list(apigwtest.VIEWER_API_KEY, "id_test", map[string]string{"limit": "500"}),
+			ExpectedBody:		errorBody(400, "limit must be an integer from 1 to 100: 500"),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing missing device **",
+			InputRequest:		list(apigwtest.DEVICE_API_KEY, "id_missing", map[string]string{"pending": ""}),
+			ExpectedBody:		errorBody(404, "Desired device with provided id was not founded"),
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		list(apigwtest.VIEWER_API_KEY, "id_error", nil),
+			ExpectedBody:		errorBody(500, "Internal Server's Error occured"),
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases, commands are listed at a fixed time
+		fake := newFakeDynamoDBAPI()
+		store := &commands.Store{DynamoDB: fake, TableName: aws.String(apigwtest.COMMANDS_TABLE_NAME), Retry: retry.Default}
+		devices := &dynamoDBAPI{DynamoDB: fake, TableName: aws.String("test_table_name"), Retry: retry.Default, Now: func() time.Time { return time.Unix(1530000000, 0) }, Commands: store}
+		handler := apigw.Chain(devices.ListCommands, apigw.Standard(apigwtest.NewServices(fake, nil), auth.SCOPE_DEVICES_READ)...)
+
+		// calls listCommands.go's ListCommands function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("GET", "/devices/{id}/commands", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if len(test.ExpectedCommands) != 0 {
+			result := types.CommandListResponse{}
+			json.Unmarshal([]byte(response.Body), &result)
+			returned := []string{}
+			for _, command := range result.Commands {
+				returned = append(returned, command.ID + ":" + command.Status)
+			}
+			if len(result.NextToken) != 0 {
+				returned = append(returned, "nextToken=" + result.NextToken)
+			}
+			if response.StatusCode != test.ExpectedStatusCode || strings.Join(returned, " ") != test.ExpectedCommands {
+				t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected commands: %s> <resulted commands: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedCommands, strings.Join(returned, " "))
+			}
+		} else if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+
+		if len(test.ExpectedStored) != 0 {
+			stored := []string{}
+			for _, id := range []string{"c1", "c2", "c3", "c4"} {
+				stored = append(stored, id + ":" + *fake.commands[id]["status"].S)
+			}
+			if strings.Join(stored, " ") != test.ExpectedStored {
+				t.Errorf("%s \n \t<expected stored commands: %s> <resulted stored commands: %s>", test.Name, test.ExpectedStored, strings.Join(stored, " "))
+			}
+		}
+	}
+
+} // end of TestListCommands function
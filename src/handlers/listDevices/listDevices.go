@@ -0,0 +1,282 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"policy"
+	"localserver"
+	"retry"
+	"tracing"
+	"types"
+	"fmt"
+	"time"
+	"context"
+	"strconv"
+	"strings"
+	"encoding/base64"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// devices of a page when ?limit= isn't sent, and the most that can be asked for
+const DEFAULT_LIMIT = 50
+const MAX_LIMIT = 100
+
+// most ?tag= filters of a request, every filter is a condition of the filter expression
+const MAX_TAG_FILTERS = 10
+
+// most Query calls of a page, filters can skip many devices so a page may end early with a nextToken
+const MAX_QUERIES = 10
+
+type SuccessResponse = types.DeviceListResponse
+
+// a ?tag=key:value filter
+type tagFilter struct {
+	Key		string
+	Value	string
+}
+
+// filters of a listing, devices must match all of them. empty Status and SeenBefore don't filter
+type deviceFilter struct {
+	Tags		[]tagFilter
+	Status		string
+	SeenBefore	string	// RFC 3339 time, devices that sent a heartbeat after it are skipped
+}
+
+// devices table of the handler, it's built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Now func() time.Time
+}
+
+// main AWS lambda function starting point.
+// It returns a page of devices of caller's tenant, devices must have all of the ?tag=key:value filters
+// and the ?status= lifecycle status when it's sent. ?stale=15m only returns devices that didn't send a heartbeat
+// in the last 15 minutes, devices that never sent one are stale too.
+// nextToken of the response is sent back as ?nextToken= to get the next page.
+func (ig *dynamoDBAPI) ListDevices(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:read scope get here (see newHandler), and they only see devices of their own tenant
+	principal := auth.FromContext(ctx)
+
+	// caller's roles must allow reading devices
+	if denied := policy.Check(principal, policy.PERMISSION_DEVICES_READ); denied != nil {
+		return *denied, nil
+	}
+
+	filter := deviceFilter{}
+	var err error
+	filter.Tags, err = parseTagFilters(localserver.QueryValues(ctx, request, "tag"))
+	if err != nil {
+		return apigw.ErrorResponse(400, err.Error()), nil
+	}
+	filter.Status, err = parseStatus(request.QueryStringParameters["status"])
+	if err != nil {
+		return apigw.ErrorResponse(400, err.Error()), nil
+	}
+	stale, err := parseStale(request.QueryStringParameters["stale"])
+	if err != nil {
+		return apigw.ErrorResponse(400, err.Error()), nil
+	}
+	if stale != 0 {
+		filter.SeenBefore = ig.Now().Add(-stale).UTC().Format(time.RFC3339)
+	}
+	limit, err := parseLimit(request.QueryStringParameters["limit"])
+	if err != nil {
+		return apigw.ErrorResponse(400, err.Error()), nil
+	}
+	startId, err := decodeToken(request.QueryStringParameters["nextToken"])
+	if err != nil {
+		return apigw.ErrorResponse(400, err.Error()), nil
+	}
+
+	devices, lastId, err := ig.queryDevices(ctx, principal.TenantID, filter, limit, startId)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	_, span := tracing.Start(ctx, "serialize response")
+	defer span.Finish()
+	return apigw.JSONResponse(200, &SuccessResponse{Devices: devices, NextToken: encodeToken(lastId)}), nil
+}
+
+// parseTagFilters parses values of ?tag=, like site:berlin. a value can contain ":" but a key can't
+func parseTagFilters(values []string) ([]tagFilter, error) {
+	if len(values) > MAX_TAG_FILTERS {
+		return nil, fmt.Errorf("At most %d tag filters can be sent", MAX_TAG_FILTERS)
+	}
+	filters := []tagFilter{}
+	for _, value := range values {
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+			return nil, fmt.Errorf("Tag filters must be like tag=key:value: %s", value)
+		}
+		filters = append(filters, tagFilter{Key: parts[0], Value: parts[1]})
+	}
+	return filters, nil
+}
+
+// parseStatus checks value of ?status= against statuses of types.StatusTransitions, empty value doesn't filter
+func parseStatus(value string) (string, error) {
+	if _, ok := types.StatusTransitions[value]; len(value) != 0 && !ok {
+		return "", fmt.Errorf("status must be one of %s: %s", strings.Join(statuses(), ", "), value)
+	}
+	return value, nil
+}
+
+// statuses in the order of a device's lifecycle
+func statuses() []string {
+	return []string{types.STATUS_PROVISIONED, types.STATUS_ACTIVE, types.STATUS_MAINTENANCE, types.STATUS_RETIRED}
+}
+
+// parseStale parses value of ?stale=, a positive duration like 15m or 2h. empty value doesn't filter
+func parseStale(value string) (time.Duration, error) {
+	if len(value) == 0 {
+		return 0, nil
+	}
+	stale, err := time.ParseDuration(value)
+	if err != nil || stale <= 0 {
+		return 0, fmt.Errorf("stale must be a positive duration like 15m: %s", value)
+	}
+	return stale, nil
+}
+
+func parseLimit(value string) (int, error) {
+	if len(value) == 0 {
+		return DEFAULT_LIMIT, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > MAX_LIMIT {
+		return 0, fmt.Errorf("limit must be an integer from 1 to %d: %s", MAX_LIMIT, value)
+	}
+	return limit, nil
+}
+
+// nextToken is the id of the last returned device, the tenant always comes from the caller
+func encodeToken(id string) string {
+	if len(id) == 0 {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(id))
+}
+
+func decodeToken(token string) (string, error) {
+	id, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", fmt.Errorf("nextToken is not valid")
+	}
+	return string(id), nil
+}
+
+// queryDevices returns up to limit devices of the tenant that match filter, after startId if it's set.
+// lastId is the id of the last evaluated device when there may be more devices, otherwise it's empty.
+func (ig *dynamoDBAPI) queryDevices(ctx context.Context, tenantId string, filter deviceFilter, limit int, startId string) ([]types.Device, string, error) {
+
+	input := &dynamodb.QueryInput{
+		TableName: ig.TableName,
+		KeyConditionExpression: aws.String("tenantId = :tenantId"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":tenantId": {
+				S: aws.String(tenantId),
+			},
+		},
+	}
+
+	// tags are a map attribute, so every filter is a condition on one of its keys
+	names := map[string]*string{}
+	conditions := []string{}
+	for i, tag := range filter.Tags {
+		names["#tags"] = aws.String("tags")
+		names[fmt.Sprintf("#k%d", i)] = aws.String(tag.Key)
+		input.ExpressionAttributeValues[fmt.Sprintf(":v%d", i)] = &dynamodb.AttributeValue{S: aws.String(tag.Value)}
+		conditions = append(conditions, fmt.Sprintf("#tags.#k%d = :v%d", i, i))
+	}
+
+	// devices stored before statuses existed don't have the attribute, they are provisioned
+	if len(filter.Status) != 0 {
+		names["#status"] = aws.String("status")
+		input.ExpressionAttributeValues[":status"] = &dynamodb.AttributeValue{S: aws.String(filter.Status)}
+		if filter.Status == types.STATUS_PROVISIONED {
+			conditions = append(conditions, "(#status = :status OR attribute_not_exists(#status))")
+		} else {
+			conditions = append(conditions, "#status = :status")
+		}
+	}
+
+	// lastSeenAt is stored in UTC with the same format, so its strings are ordered like the times
+	if len(filter.SeenBefore) != 0 {
+		names["#lastSeenAt"] = aws.String("lastSeenAt")
+		input.ExpressionAttributeValues[":seenBefore"] = &dynamodb.AttributeValue{S: aws.String(filter.SeenBefore)}
+		conditions = append(conditions, "(attribute_not_exists(#lastSeenAt) OR #lastSeenAt < :seenBefore)")
+	}
+
+	if len(conditions) != 0 {
+		input.ExpressionAttributeNames = names
+		input.FilterExpression = aws.String(strings.Join(conditions, " AND "))
+	}
+
+	devices := []types.Device{}
+	lastId := startId
+	for queries := 0; queries < MAX_QUERIES; queries++ {
+		if len(lastId) != 0 {
+			input.ExclusiveStartKey = map[string]*dynamodb.AttributeValue{
+				"tenantId": {
+					S: aws.String(tenantId),
+				},
+				"id": {
+					S: aws.String(lastId),
+				},
+			}
+		}
+		// Limit is applied before the filter, so a page is filled by following queries
+		input.Limit = aws.Int64(int64(limit - len(devices)))
+
+		var output *dynamodb.QueryOutput
+		err := ig.Retry.Do(ctx, func() (err error) {
+			output, err = ig.DynamoDB.QueryWithContext(ctx, input)
+			return err
+		})
+		if err != nil {
+			return nil, "", err
+		}
+
+		page := []types.Device{}
+		if err := dynamodbattribute.UnmarshalListOfMaps(output.Items, &page); err != nil {
+			return nil, "", err
+		}
+		devices = append(devices, page...)
+
+		lastId = ""
+		if id, ok := output.LastEvaluatedKey["id"]; ok && id.S != nil {
+			lastId = *id.S
+		}
+		if len(lastId) == 0 || len(devices) >= limit {
+			break
+		}
+	}
+	return devices, lastId, nil
+}
+
+// newHandler wraps ListDevices with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services.
+func newHandler(services *apigw.Services) apigw.Handler {
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Now: time.Now}
+	return apigw.Chain(table.ListDevices, apigw.Standard(services, auth.SCOPE_DEVICES_READ)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("listDevices")...)
+}
@@ -0,0 +1,296 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"localserver"
+	"retry"
+	"testing"
+	"context"
+	"fmt"
+	"time"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	InputTags 					[]string
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+	ExpectedQueries 			int
+}
+
+// A fakeDynamoDB instance for mocking test that emulates real DynamoDB
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	Queries	int
+}
+
+// devices of tenant_test, sorted by id like the range key of the table
+var fakeDevices = []map[string]*dynamodb.AttributeValue{
+	{
+		"id": {S: aws.String("id_a")},
+		"deviceModel": {S: aws.String("deviceModel_test")},
+		"name": {S: aws.String("name_a")},
+		"note": {S: aws.String("note_test")},
+		"serial": {S: aws.String("serial_test")},
+		"tags": {M: map[string]*dynamodb.AttributeValue{"site": {S: aws.String("berlin")}}},
+	},
+	{
+		"id": {S: aws.String("id_b")},
+		"deviceModel": {S: aws.String("deviceModel_test")},
+		"name": {S: aws.String("name_b")},
+		"note": {S: aws.String("note_test")},
+		"serial": {S: aws.String("serial_test")},
+		"tags": {M: map[string]*dynamodb.AttributeValue{"site": {S: aws.String("paris")}}},
+		"status": {S: aws.String("active")},
+		"lastSeenAt": {S: aws.String("2018-06-26T07:55:00Z")},
+	},
+	{
+		"id": {S: aws.String("id_c")},
+		"deviceModel": {S: aws.String("deviceModel_test")},
+		"name": {S: aws.String("name_c")},
+		"note": {S: aws.String("note_test")},
+		"serial": {S: aws.String("serial_test")},
+		"tags": {M: map[string]*dynamodb.AttributeValue{"site": {S: aws.String("berlin")}, "floor": {S: aws.String("2")}}},
+		"lastSeenAt": {S: aws.String("2018-06-26T07:30:00Z")},
+	},
+}
+
+// a mocked version of DynamoDB's Query function.
+// like DynamoDB, Limit is applied to evaluated items before the filter, conditions of the filter are "#tags.#k<i> = :v<i>"
+// and status and lastSeenAt conditions, items without status match provisioned and items without lastSeenAt are stale
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	fd.Queries++
+	output := &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{}}
+	tenantId := *input.ExpressionAttributeValues[":tenantId"].S
+
+	if tenantId == "tenant_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	if tenantId != "tenant_test" {
+		return output, nil
+	}
+
+	start := 0
+	if input.ExclusiveStartKey != nil {
+		for i, item := range fakeDevices {
+			if *item["id"].S == *input.ExclusiveStartKey["id"].S {
+				start = i + 1
+			}
+		}
+	}
+
+	evaluated := 0
+	for i := start; i < len(fakeDevices); i++ {
+		evaluated++
+		if input.Limit != nil && int64(evaluated) == *input.Limit {
+			output.LastEvaluatedKey = map[string]*dynamodb.AttributeValue{"tenantId": {S: aws.String(tenantId)}, "id": fakeDevices[i]["id"]}
+		}
+
+		item := fakeDevices[i]
+		matches := true
+		for k := 0; input.ExpressionAttributeNames[fmt.Sprintf("#k%d", k)] != nil; k++ {
+			key := *input.ExpressionAttributeNames[fmt.Sprintf("#k%d", k)]
+			value := *input.ExpressionAttributeValues[fmt.Sprintf(":v%d", k)].S
+			if tag, ok := item["tags"].M[key]; !ok || *tag.S != value {
+				matches = false
+			}
+		}
+		if value, ok := input.ExpressionAttributeValues[":status"]; ok {
+			status := "provisioned"
+			if item["status"] != nil {
+				status = *item["status"].S
+			}
+			matches = matches && status == *value.S
+		}
+		if value, ok := input.ExpressionAttributeValues[":seenBefore"]; ok && item["lastSeenAt"] != nil {
+			matches = matches && *item["lastSeenAt"].S < *value.S
+		}
+		if matches {
+			output.Items = append(output.Items, item)
+		}
+		if output.LastEvaluatedKey != nil {
+			break
+		}
+	}
+	return output, nil
+}
+
+// a viewer key that only has devices:write scope, keys of apigwtest have devices:read too, and a viewer key of
+// "tenant_error" whose devices can't be listed
+const WRITE_API_KEY = "writekey." + apigwtest.SECRET
+const ERROR_API_KEY = "errorkey." + apigwtest.SECRET
+
+var testKeys = &apigwtest.FakeKeysDynamoDBAPI{Keys: []auth.ApiKey{
+	{ID: "writekey", Scopes: []string{auth.SCOPE_DEVICES_WRITE}, Roles: []string{"viewer"}, TenantID: apigwtest.TENANT},
+	{ID: "errorkey", Scopes: []string{auth.SCOPE_DEVICES_READ}, Roles: []string{"viewer"}, TenantID: "tenant_error"},
+}}
+
+func TestListDevices(t *testing.T) {
+
+	deviceA := "{\n\t\t\t\"id\": \"id_a\",\n\t\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\t\"name\": \"name_a\",\n\t\t\t\"note\": \"note_test\",\n\t\t\t\"serial\": \"serial_test\",\n\t\t\t\"tags\": {\n\t\t\t\t\"site\": \"berlin\"\n\t\t\t}\n\t\t}"
+	deviceB := "{\n\t\t\t\"id\": \"id_b\",\n\t\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\t\"name\": \"name_b\",\n\t\t\t\"note\": \"note_test\",\n\t\t\t\"serial\": \"serial_test\",\n\t\t\t\"tags\": {\n\t\t\t\t\"site\": \"paris\"\n\t\t\t},\n\t\t\t\"status\": \"active\",\n\t\t\t\"lastSeenAt\": \"2018-06-26T07:55:00Z\"\n\t\t}"
+	deviceC := "{\n\t\t\t\"id\": \"id_c\",\n\t\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\t\"name\": \"name_c\",\n\t\t\t\"note\": \"note_test\",\n\t\t\t\"serial\": \"serial_test\",\n\t\t\t\"tags\": {\n\t\t\t\t\"floor\": \"2\",\n\t\t\t\t\"site\": \"berlin\"\n\t\t\t},\n\t\t\t\"lastSeenAt\": \"2018-06-26T07:30:00Z\"\n\t\t}"
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing api key without devices:read scope **",
+			InputRequest:		events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": WRITE_API_KEY}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 403,\n\t\t\"message\": \"API key lacks required scope: devices:read\"\n\t}\n}",
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name:				"** Testing all devices of the tenant **",
+			InputRequest:		events.APIGatewayProxyRequest{},
+			ExpectedBody:		"{\n\t\"data\": [\n\t\t" + deviceA + ",\n\t\t" + deviceB + ",\n\t\t" + deviceC + "\n\t]\n}",
+			ExpectedStatusCode:	200,
+			ExpectedQueries:	1,
+		},
+		{
+			Name:				"** Testing a tag filter **",
+			InputRequest:		events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"tag": "site:berlin"}},
+			ExpectedBody:		"{\n\t\"data\": [\n\t\t" + deviceA + ",\n\t\t" + deviceC + "\n\t]\n}",
+			ExpectedStatusCode:	200,
+			ExpectedQueries:	1,
+		},
+		{
+			Name:				"** Testing repeated tag filters **",
+			InputRequest:		events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"tag": "floor:2"}},
+			InputTags:			[]string{"site:berlin", "floor:2"},
+			ExpectedBody:		"{\n\t\"data\": [\n\t\t" + deviceC + "\n\t]\n}",
+			ExpectedStatusCode:	200,
+			ExpectedQueries:	1,
+		},
+		{
+			Name:				"** Testing a page limit **",
+			InputRequest:		events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"tag": "site:berlin", "limit": "1"}},
+			ExpectedBody:		"{\n\t\"data\": [\n\t\t" + deviceA + "\n\t],\n\t\"nextToken\": \"aWRfYQ\"\n}",
+			ExpectedStatusCode:	200,
+			ExpectedQueries:	1,
+		},
+		{
+			Name:				"** Testing a next page that is filled by following queries **",
+			InputRequest:		events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"tag": "site:berlin", "limit": "1", "nextToken": "aWRfYQ"}},
+			ExpectedBody:		"{\n\t\"data\": [\n\t\t" + deviceC + "\n\t],\n\t\"nextToken\": \"aWRfYw\"\n}",
+			ExpectedStatusCode:	200,
+			ExpectedQueries:	2,
+		},
+		{
+			Name:				"** Testing the last page **",
+			InputRequest:		events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"tag": "site:berlin", "limit": "1", "nextToken": "aWRfYw"}},
+			ExpectedBody:		"{\n\t\"data\": []\n}",
+			ExpectedStatusCode:	200,
+			ExpectedQueries:	1,
+		},
+		{
+			Name:				"** Testing a status filter **",
+			InputRequest:		events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"status": "active"}},
+			ExpectedBody:		"{\n\t\"data\": [\n\t\t" + deviceB + "\n\t]\n}",
+			ExpectedStatusCode:	200,
+			ExpectedQueries:	1,
+		},
+		{
+			Name:				"** Testing devices stored without status are provisioned **",
+			InputRequest:		events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"status": "provisioned", "tag": "site:berlin"}},
+			ExpectedBody:		"{\n\t\"data\": [\n\t\t" + deviceA + ",\n\t\t" + deviceC + "\n\t]\n}",
+			ExpectedStatusCode:	200,
+			ExpectedQueries:	1,
+		},
+		{
+			Name:				"** Testing invalid status filter **",
+			InputRequest:		events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"status": "broken"}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"status must be one of provisioned, active, maintenance, retired: broken\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing stale devices **",
+			InputRequest:		events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"stale": "15m"}},
+			ExpectedBody:		"{\n\t\"data\": [\n\t\t" + deviceA + ",\n\t\t" + deviceC + "\n\t]\n}",
+			ExpectedStatusCode:	200,
+			ExpectedQueries:	1,
+		},
+		{
+			Name:				"** Testing invalid stale window **",
+			InputRequest:		events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"stale": "-5m"}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"stale must be a positive duration like 15m: -5m\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing invalid tag filter **",
+			InputRequest:		events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"tag": "site"}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Tag filters must be like tag=key:value: site\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing too many tag filters **",
+			InputRequest:		events.APIGatewayProxyRequest{},
+			InputTags:			[]string{"a:1", "b:1", "c:1", "d:1", "e:1", "f:1", "g:1", "h:1", "i:1", "j:1", "k:1"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"At most 10 tag filters can be sent\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing invalid limit **",
+			InputRequest:		events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"limit": "101"}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"limit must be an integer from 1 to 100: 101\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing invalid nextToken **",
+			InputRequest:		events.APIGatewayProxyRequest{QueryStringParameters: map[string]string{"nextToken": "%%"}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"nextToken is not valid\"\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": ERROR_API_KEY}},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}",
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases, stale windows end at a fixed time
+		devices := &FakeDynamoDBAPI{}
+		listing := &dynamoDBAPI{DynamoDB: devices, TableName: aws.String("test_table_name"), Retry: retry.Default, Now: func() time.Time { return time.Unix(1530000000, 0) }}
+		handler := apigw.Chain(listing.ListDevices, apigw.Standard(apigwtest.NewServices(devices, testKeys), auth.SCOPE_DEVICES_READ)...)
+
+		// requests without explicit headers are sent with a valid read key
+		if test.InputRequest.Headers == nil {
+			test.InputRequest.Headers = map[string]string{"X-Api-Key": apigwtest.VIEWER_API_KEY}
+		}
+
+		// repeated values come from the event's multi value parameters
+		ctx := context.Background()
+		if test.InputTags != nil {
+			ctx = localserver.WithQueryValues(ctx, map[string][]string{"tag": test.InputTags})
+		}
+
+		// calls listDevices.go's ListDevices function.
+		response, _ := handler(ctx, test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("GET", "/devices", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+
+		if test.ExpectedQueries != 0 && devices.Queries != test.ExpectedQueries {
+			t.Errorf("%s \n \t<expected queries: %d> <resulted queries: %d>", test.Name, test.ExpectedQueries, devices.Queries)
+		}
+	}
+
+} // end of TestListDevices function
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"firmware"
+	"policy"
+	"localserver"
+	"types"
+	"fmt"
+	"sort"
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+type SuccessResponse = types.FirmwareListResponse
+
+// firmware store of the handler, it's built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	Firmware *firmware.Store
+}
+
+// main AWS lambda function starting point.
+// It returns firmware versions of a device model in the catalog of caller's tenant, newest first. A model without
+// versions has an empty list, devices of any model can exist before its firmware is added.
+func (ig *dynamoDBAPI) ListFirmware(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:read scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+	if denied := policy.Check(principal, policy.PERMISSION_FIRMWARE_READ); denied != nil {
+		return *denied, nil
+	}
+
+	model := request.PathParameters["model"]
+	if model == "" {
+		return apigw.ErrorResponse(404, "No Model Field Provided"), nil
+	}
+
+	versions, err := ig.Firmware.ListFirmware(ctx, principal.TenantID, model)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	// the table sorts versions as strings (e.g. 10.0 before 9.0), so they are ordered by the time they are added
+	sort.SliceStable(versions, func(i, j int) bool {
+		return versions[i].CreatedAt > versions[j].CreatedAt
+	})
+	return apigw.JSONResponse(200, &SuccessResponse{Firmware: versions}), nil
+}
+
+// newHandler wraps ListFirmware with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services. The firmware table is only needed by firmware handlers, so it's checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	if len(services.Config.FirmwareTableName) == 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: []string{"FIRMWARE_TABLE_NAME is not set"}}
+	}
+	store := &firmware.Store{DynamoDB: services.DynamoDB, FirmwareTable: aws.String(services.Config.FirmwareTableName), Retry: services.Retry}
+	catalog := &dynamoDBAPI{Firmware: store}
+	return apigw.Chain(catalog.ListFirmware, apigw.Standard(services, auth.SCOPE_DEVICES_READ)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("listFirmware")...)
+}
@@ -0,0 +1,98 @@
+package main
+
+import(
+	"api"
+	"apigwtest"
+	"firmware"
+	"types"
+	"testing"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+}
+
+// A fakeDynamoDB instance for mocking test that emulates the firmware table with versions "10.0.0" and "9.2.0" of
+// "thermo-2" of "tenant_test", sorted as strings like DynamoDB does. Every version is a page of its own.
+// Model "error" fails.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+// a mocked version of DynamoDB's Query function
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	model := *input.ExpressionAttributeValues[":model"].S
+	if model == firmware.ModelKey("tenant_test", "error") {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	if model != firmware.ModelKey("tenant_test", "thermo-2") {
+		return &dynamodb.QueryOutput{}, nil
+	}
+
+	older, _ := dynamodbattribute.MarshalMap(types.Firmware{DeviceModel: "thermo-2", Version: "9.2.0", Checksum: "sha256:aa", CreatedAt: "2018-06-20T08:00:00Z"})
+	newer, _ := dynamodbattribute.MarshalMap(types.Firmware{DeviceModel: "thermo-2", Version: "10.0.0", Checksum: "sha256:bb", ReleaseNotes: "New sensor driver", CreatedAt: "2018-06-25T08:00:00Z"})
+	if input.ExclusiveStartKey == nil {
+		return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{newer}, LastEvaluatedKey: map[string]*dynamodb.AttributeValue{"version": newer["version"]}}, nil
+	}
+	return &dynamodb.QueryOutput{Items: []map[string]*dynamodb.AttributeValue{older}}, nil
+}
+
+func TestListFirmware(t *testing.T) {
+
+	list := func(model string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.VIEWER_API_KEY}, PathParameters: map[string]string{"model": model}}
+	}
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing versions of a model **",
+			InputRequest:		list("thermo-2"),
+			ExpectedBody:		"{\n\t\"data\": [\n\t\t{\n\t\t\t\"deviceModel\": \"thermo-2\",\n\t\t\t\"version\": \"10.0.0\",\n\t\t\t\"checksum\": \"sha256:bb\",\n\t\t\t\"releaseNotes\": \"New sensor driver\",\n\t\t\t\"createdAt\": \"2018-06-25T08:00:00Z\"\n\t\t},\n" +
+				"\t\t{\n\t\t\t\"deviceModel\": \"thermo-2\",\n\t\t\t\"version\": \"9.2.0\",\n\t\t\t\"checksum\": \"sha256:aa\",\n\t\t\t\"createdAt\": \"2018-06-20T08:00:00Z\"\n\t\t}\n\t]\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing model without versions **",
+			InputRequest:		list("thermo-3"),
+			ExpectedBody:		"{\n\t\"data\": []\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		list("error"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}",
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases
+		handler := newHandler(apigwtest.NewServices(&FakeDynamoDBAPI{}, nil))
+
+		// calls listFirmware.go's ListFirmware function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("GET", "/firmware/{model}", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+
+} // end of TestListFirmware function
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"devices"
+	"firmware"
+	"policy"
+	"localserver"
+	"retry"
+	"types"
+	"fmt"
+	"context"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+var ErrDeviceNotFound = errors.New("device not found")
+
+type SuccessResponse = types.NextFirmwareResponse
+
+// devices table and firmware store of the handler, they are built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Firmware *firmware.Store
+}
+
+// main AWS lambda function starting point.
+// It returns the firmware version that a device of caller's tenant should install. The newest active campaign that
+// targets the device and includes it by its current stage decides, a device that already reports the campaign's
+// version (or that no campaign includes) is up to date. Devices verify the checksum before installing the version.
+func (ig *dynamoDBAPI) NextFirmware(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:read scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+	if denied := policy.Check(principal, policy.PERMISSION_FIRMWARE_READ); denied != nil {
+		return *denied, nil
+	}
+
+	id := request.PathParameters["id"]
+	if id == "" {
+		return apigw.ErrorResponse(404, "No ID Field Provided"), nil
+	}
+
+	device, err := ig.getDevice(ctx, principal.TenantID, id)
+	if err == ErrDeviceNotFound {
+		devices.LogCrossTenantAccess(ctx, ig.DynamoDB, ig.TableName, principal, id)
+		return apigw.ErrorResponse(404, "Desired device with provided id was not founded"), nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	campaigns, err := ig.Firmware.ActiveCampaigns(ctx, principal.TenantID, device.DeviceModel)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	campaign, ok := firmware.Next(campaigns, device)
+	if !ok || campaign.Version == device.FirmwareVersion {
+		return apigw.JSONResponse(200, &SuccessResponse{Status: "up to date"}), nil
+	}
+
+	// versions of the catalog can't be removed, so a campaign's version is always there
+	next, err := ig.Firmware.GetFirmware(ctx, principal.TenantID, campaign.DeviceModel, campaign.Version)
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+	return apigw.JSONResponse(200, &SuccessResponse{Status: "update available", CampaignID: campaign.ID, Firmware: &next}), nil
+}
+
+// function that returns a device of the tenant, ErrDeviceNotFound when it doesn't exist. only the attributes that
+// campaigns target by and the reported firmware version are read
+func (ig *dynamoDBAPI) getDevice(ctx context.Context, tenantId string, id string) (types.Device, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: ig.TableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ProjectionExpression: aws.String("id, deviceModel, tags, #status, firmwareVersion"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+	}
+
+	var output *dynamodb.GetItemOutput
+	err := ig.Retry.Do(ctx, func() (err error) {
+		output, err = ig.DynamoDB.GetItemWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return types.Device{}, err
+	}
+	if len(output.Item) == 0 {
+		return types.Device{}, ErrDeviceNotFound
+	}
+
+	device := types.Device{}
+	err = dynamodbattribute.UnmarshalMap(output.Item, &device)
+	return device, err
+}
+
+// newHandler wraps NextFirmware with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services. The firmware and campaigns tables are only needed by firmware handlers,
+// so they are checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	problems := []string{}
+	if len(services.Config.FirmwareTableName) == 0 {
+		problems = append(problems, "FIRMWARE_TABLE_NAME is not set")
+	}
+	if len(services.Config.CampaignsTableName) == 0 {
+		problems = append(problems, "CAMPAIGNS_TABLE_NAME is not set")
+	}
+	if len(problems) != 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: problems}
+	}
+	store := &firmware.Store{DynamoDB: services.DynamoDB, FirmwareTable: aws.String(services.Config.FirmwareTableName), CampaignsTable: aws.String(services.Config.CampaignsTableName), Retry: services.Retry}
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Firmware: store}
+	return apigw.Chain(table.NextFirmware, apigw.Standard(services, auth.SCOPE_DEVICES_READ)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("nextFirmware")...)
+}
@@ -0,0 +1,151 @@
+package main
+
+import(
+	"api"
+	"apigwtest"
+	"firmware"
+	"types"
+	"testing"
+	"context"
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+}
+
+// A fakeDynamoDB instance for mocking test that emulates devices, firmware and campaigns tables of "tenant_test".
+// Active campaigns of "thermo-2" are "c_next" (newest, "2.2.0" to devices at site berlin, its first stage includes
+// buckets below 50) and "c_first" ("2.1.0" to every device). Buckets of devices in "c_next" are 12 for "id_1",
+// 52 for "id_2" and 58 for "id_updated". Device "id_error" fails.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+// a mocked version of DynamoDB's GetItem function, for both of devices and firmware tables
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	output := new(dynamodb.GetItemOutput)
+	if *input.TableName == apigwtest.FIRMWARE_TABLE_NAME {
+		notes := map[string]string{"2.1.0": "Fixes drift of the sensor", "2.2.0": "New sensor driver"}
+		version := *input.Key["version"].S
+		if *input.Key["model"].S == firmware.ModelKey("tenant_test", "thermo-2") && len(notes[version]) != 0 {
+			item, _ := dynamodbattribute.MarshalMap(types.Firmware{DeviceModel: "thermo-2", Version: version, Checksum: "sha256:" + version, ReleaseNotes: notes[version], CreatedAt: "2018-06-20T08:00:00Z"})
+			output.SetItem(item)
+		}
+		return output, nil
+	}
+
+	id := *input.Key["id"].S
+	if id == "id_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	berlin := map[string]string{"site": "berlin"}
+	devices := map[string]types.Device{
+		"id_1":			{ID: "id_1", DeviceModel: "thermo-2", Tags: berlin, FirmwareVersion: "2.0.0"},
+		"id_2":			{ID: "id_2", DeviceModel: "thermo-2", Tags: berlin, FirmwareVersion: "2.0.0"},
+		"id_updated":	{ID: "id_updated", DeviceModel: "thermo-2", Tags: map[string]string{"site": "paris"}, FirmwareVersion: "2.1.0"},
+		"id_3":			{ID: "id_3", DeviceModel: "thermo-3"},
+	}
+	if device, ok := devices[id]; ok && *input.Key["tenantId"].S == "tenant_test" {
+		item, _ := dynamodbattribute.MarshalMap(device)
+		output.SetItem(item)
+	}
+	return output, nil
+}
+
+// a mocked version of DynamoDB's Query function, for the index of active campaigns. campaigns are returned newest first
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	if *input.IndexName == types.DEVICES_ID_INDEX {
+		// cross-tenant access attempts are logged, devices of other tenants aren't known
+		return &dynamodb.QueryOutput{}, nil
+	}
+	output := &dynamodb.QueryOutput{}
+	if *input.IndexName != firmware.ACTIVE_CAMPAIGNS_INDEX || *input.ExpressionAttributeValues[":model"].S != "tenant_test#thermo-2" {
+		return output, nil
+	}
+	campaigns := []types.Campaign{
+		{ID: "c_next", DeviceModel: "thermo-2", Version: "2.2.0", Tags: map[string]string{"site": "berlin"}, Stages: []int{50, 100}, Status: types.CAMPAIGN_ACTIVE},
+		{ID: "c_first", DeviceModel: "thermo-2", Version: "2.1.0", Stages: []int{100}, Status: types.CAMPAIGN_ACTIVE},
+	}
+	for _, campaign := range campaigns {
+		item, _ := dynamodbattribute.MarshalMap(campaign)
+		output.Items = append(output.Items, item)
+	}
+	return output, nil
+}
+
+func TestNextFirmware(t *testing.T) {
+
+	next := func(id string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.DEVICE_API_KEY}, PathParameters: map[string]string{"id": id}}
+	}
+	upToDate := "{\n\t\"status\": \"up to date\"\n}"
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing device of the first stage of the newest campaign **",
+			InputRequest:		next("id_1"),
+			ExpectedBody:		"{\n\t\"status\": \"update available\",\n\t\"campaignId\": \"c_next\",\n\t\"data\": {\n\t\t\"deviceModel\": \"thermo-2\",\n\t\t\"version\": \"2.2.0\",\n\t\t\"checksum\": \"sha256:2.2.0\",\n\t\t\"releaseNotes\": \"New sensor driver\",\n\t\t\"createdAt\": \"2018-06-20T08:00:00Z\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing device out of the first stage of the newest campaign **",
+			InputRequest:		next("id_2"),
+			ExpectedBody:		"{\n\t\"status\": \"update available\",\n\t\"campaignId\": \"c_first\",\n\t\"data\": {\n\t\t\"deviceModel\": \"thermo-2\",\n\t\t\"version\": \"2.1.0\",\n\t\t\"checksum\": \"sha256:2.1.0\",\n\t\t\"releaseNotes\": \"Fixes drift of the sensor\",\n\t\t\"createdAt\": \"2018-06-20T08:00:00Z\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing device that reports the campaign's version **",
+			InputRequest:		next("id_updated"),
+			ExpectedBody:		upToDate,
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing device of a model without campaigns **",
+			InputRequest:		next("id_3"),
+			ExpectedBody:		upToDate,
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing missing device **",
+			InputRequest:		next("id_missing"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Desired device with provided id was not founded\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		next("id_error"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 500,\n\t\t\"message\": \"Internal Server's Error occured\"\n\t}\n}",
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases
+		handler := newHandler(apigwtest.NewServices(&FakeDynamoDBAPI{}, nil))
+
+		// calls nextFirmware.go's NextFirmware function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("GET", "/devices/{id}/firmware/next", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+
+} // end of TestNextFirmware function
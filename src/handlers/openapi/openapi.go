@@ -0,0 +1,43 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"config"
+	"localserver"
+	"fmt"
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// build version of the handler, it's set by the Makefile with -ldflags "-X main.version=..."
+var version = "dev"
+
+// main AWS lambda function starting point.
+// It returns the OpenAPI document, it's generated from api.Routes and types of request and response bodies.
+func OpenAPI(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	response := apigw.JSONResponse(200, api.Document(version))
+	apigw.SetHeader(&response, "Content-Type", "application/json")
+	return response, nil
+}
+
+// newHandler wraps OpenAPI with the shared middlewares that don't need a valid configuration,
+// the document is public and isn't rate limited.
+func newHandler(services *apigw.Services) apigw.Handler {
+	return apigw.Chain(OpenAPI,
+		apigw.Logging(),
+		apigw.Trace(services.Tracer),
+		apigw.Measure(services.Metrics),
+		apigw.CORS(services.Config.CORSAllowedOrigin),
+		apigw.Recover(),
+	)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("openapi")...)
+}
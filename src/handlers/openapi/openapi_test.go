@@ -0,0 +1,35 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"config"
+	"testing"
+	"context"
+	"strings"
+	"encoding/json"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+func TestOpenAPI(t *testing.T) {
+
+	// the document is public, so the handler works without keys and database
+	handler := newHandler(&apigw.Services{Config: config.Default()})
+
+	response, _ := handler(context.Background(), events.APIGatewayProxyRequest{HTTPMethod: "GET"})
+
+	if response.StatusCode != 200 || response.Headers["Content-Type"] != "application/json" {
+		t.Errorf("** Testing document response ** \n \t<expected error-code: %d> <resulted error-code: %d> <resulted headers: %v>", 200, response.StatusCode, response.Headers)
+	}
+
+	document := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(response.Body), &document); err != nil {
+		t.Fatalf("** Testing document body ** \n \t<resulted error: %s>", err)
+	}
+	if document["openapi"] != api.OPENAPI_VERSION || !strings.Contains(response.Body, "\"/devices/{id}\"") {
+		t.Errorf("** Testing document body ** \n \t<resulted body: %s>", response.Body)
+	}
+	for _, problem := range api.CheckResponse("GET", "/openapi.json", response) {
+		t.Errorf("** Testing document body ** \n \t<response drifted from the document: %s>", problem)
+	}
+} // end of TestOpenAPI function
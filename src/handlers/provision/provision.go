@@ -0,0 +1,202 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"provisioning"
+	"localserver"
+	"metrics"
+	"retry"
+	"types"
+	"fmt"
+	"time"
+	"context"
+	"strings"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// reasons of rejected inputs, they are Reason dimension of ValidationFailures metric
+const REASON_EMPTY_BODY = "empty_body"
+const REASON_INVALID_JSON = "invalid_json"
+const REASON_SCHEMA_VIOLATION = "schema_violation"
+
+type SuccessResponse = types.ProvisionResponse
+
+// devices table, provisioning store and api keys of the handler, they are built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Now func() time.Time
+	Claims *provisioning.Store
+	Keys *auth.KeyStore
+}
+
+// main AWS lambda function starting point.
+// It provisions a device by its serial and the one-time claim token that is issued for it, callers don't have an API key
+// yet. The device is created in the claim's tenant and gets an api key that only works on its own routes. The claim is
+// redeemed last and conditionally, so of concurrent calls only one gets a credential, the keys of the others are revoked.
+func (ig *dynamoDBAPI) Provision(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	provisionRequest, reason, err := validateProvision(request)
+	if err != nil {
+		metrics.ValidationFailure(ctx, reason)
+		return events.APIGatewayProxyResponse{
+			Body:	err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// unknown claims, wrong secrets and serials are reported the same way, so tokens can't be probed
+	id, secret := provisioning.SplitToken(provisionRequest.Token)
+	if len(id) == 0 {
+		return apigw.ErrorResponse(401, "Invalid claim token"), nil
+	}
+	claim, err := ig.Claims.Get(ctx, id)
+	if err == provisioning.ErrClaimNotFound {
+		return apigw.ErrorResponse(401, "Invalid claim token"), nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	now := ig.Now()
+	switch claim.Verify(provisionRequest.Serial, secret, now) {
+	case provisioning.ErrInvalidToken:
+		return apigw.ErrorResponse(401, "Invalid claim token"), nil
+	case provisioning.ErrClaimExpired:
+		return apigw.ErrorResponse(401, "Claim token is expired, please ask for a new one"), nil
+	case provisioning.ErrClaimUsed:
+		return apigw.ErrorResponse(409, "Claim token is already used"), nil
+	}
+
+	if err := ig.insertDevice(ctx, claim); err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	plainKey, apiKey, err := auth.GenerateKey("device " + claim.DeviceID, claim.TenantID, provisioning.DEVICE_SCOPES, provisioning.DEVICE_ROLES, now.UTC().Format(time.RFC3339))
+	apiKey.DeviceID = claim.DeviceID
+	if err == nil {
+		err = ig.Keys.PutKey(ctx, apiKey)
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	err = ig.Claims.Redeem(ctx, claim, apiKey.ID, now)
+	if err == provisioning.ErrClaimUsed {
+		// another call redeemed the claim (or it expired) meanwhile, the key of this call must not work
+		if err := ig.Keys.RevokeKey(ctx, apiKey.ID); err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+		return apigw.ErrorResponse(409, "Claim token is already used"), nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	provisioned := types.ProvisionedDevice{DeviceID: claim.DeviceID, TenantID: claim.TenantID, KeyID: apiKey.ID, Key: plainKey}
+	return apigw.JSONResponse(201, &SuccessResponse{Status: "device provisioned", Device: provisioned}), nil
+}
+
+// validateProvision returns the provision request of the body, or reason and error body of rejecting it.
+// The body is validated against the provision schema (GET /schemas/provision.json).
+func validateProvision(request events.APIGatewayProxyRequest) (types.ProvisionRequest, string, error) {
+	provisionRequest := types.ProvisionRequest{}
+	if len(strings.TrimSpace(request.Body)) == 0 {
+		return provisionRequest, REASON_EMPTY_BODY, errors.New(createErrorResponseJson(400, "No inputs provided, please provide inputs in json format."))
+	}
+
+	var body interface{}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return provisionRequest, REASON_INVALID_JSON, errors.New(createErrorResponseJson(400, "Wrong format: Inputs must be a valid json."))
+	}
+
+	if violations := api.ValidateProvision(body); len(violations) != 0 {
+		errorMessage := "Provision request doesn't match its schema " + api.SCHEMAS_PATH + "provision.json"
+		return provisionRequest, REASON_SCHEMA_VIOLATION, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
+	}
+	json.Unmarshal([]byte(request.Body), &provisionRequest)
+	return provisionRequest, "", nil
+}
+
+// function that inserts the device of a claim into the claim's tenant as provisioned. A device that exists is
+// left as it is, it's inserted by an earlier call of the same claim that didn't finish.
+func (ig *dynamoDBAPI) insertDevice(ctx context.Context, claim provisioning.Claim) error {
+	device := types.Device{
+		ID:				claim.DeviceID,
+		DeviceModel:	claim.DeviceModel,
+		Name:			claim.Serial,
+		Note:			"provisioned by claim " + claim.ID,
+		Serial:			claim.Serial,
+		Status:			types.STATUS_PROVISIONED,
+	}
+	item, _ := dynamodbattribute.MarshalMap(device)
+	item["tenantId"] = &dynamodb.AttributeValue{S: aws.String(claim.TenantID)}
+
+	input := &dynamodb.PutItemInput{
+		Item: item,
+		TableName: ig.TableName,
+		ConditionExpression: aws.String("attribute_not_exists(id)"),
+	}
+	err := ig.Retry.Do(ctx, func() error {
+		_, err := ig.DynamoDB.PutItemWithContext(ctx, input)
+		return err
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return nil
+	}
+	return err
+}
+
+func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
+	return types.NewErrorResponseJson(errorCode, errorMessage)
+}
+
+// newHandler wraps Provision with the shared middlewares except authentication and rate limiting, devices don't have
+// an API key before they are provisioned. Invalid claim tokens (401) are limited by source ip instead. All of its
+// dependencies come from services. The provisioning and api keys tables are checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	problems := []string{}
+	if len(services.Config.ProvisioningTableName) == 0 {
+		problems = append(problems, "PROVISIONING_TABLE_NAME is not set")
+	}
+	if len(services.Config.ApiKeysTableName) == 0 {
+		problems = append(problems, "API_KEYS_TABLE_NAME is not set")
+	}
+	if len(problems) != 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: problems}
+	}
+	store := &provisioning.Store{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.ProvisioningTableName), Retry: services.Retry}
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Now: time.Now, Claims: store, Keys: services.Keys}
+	return apigw.Chain(table.Provision,
+		apigw.Logging(),
+		apigw.Trace(services.Tracer),
+		apigw.Measure(services.Metrics),
+		apigw.CORS(services.Config.CORSAllowedOrigin),
+		apigw.Recover(),
+		apigw.ErrorMapping(),
+		apigw.Deadline(services.Config.DeadlineMargin),
+		apigw.RequireConfig(services),
+		apigw.LimitFailedAuth(services.Limiter),
+	)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("provision")...)
+}
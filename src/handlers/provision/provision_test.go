@@ -0,0 +1,273 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"provisioning"
+	"retry"
+	"types"
+	"testing"
+	"context"
+	"time"
+	"errors"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+}
+
+const DEVICES_TABLE_NAME = "test_table_name"
+const KEYS_TABLE_NAME = "test_keys_table_name"
+const PROVISIONING_TABLE_NAME = "test_provisioning_table_name"
+
+// A fakeDynamoDB instance for mocking test that emulates devices, api keys and provisioning tables, it keeps items
+// by table and id. Secrets of claims are "secret": "claim1" of serial "SN-1" is unused, "claim_expired" is expired,
+// "claim_used" is used and "claim_race" is redeemed by another call while it's provisioned. The first redeem of
+// "claim_lost" loses its response. "claim_error" fails.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	items	map[string]map[string]map[string]*dynamodb.AttributeValue
+}
+
+func newFakeDynamoDBAPI() *FakeDynamoDBAPI {
+	fake := &FakeDynamoDBAPI{items: map[string]map[string]map[string]*dynamodb.AttributeValue{DEVICES_TABLE_NAME: {}, KEYS_TABLE_NAME: {}, PROVISIONING_TABLE_NAME: {}}}
+	claims := []provisioning.Claim{
+		{ID: "claim1", ExpiresAt: "2018-06-27T08:00:00Z"},
+		{ID: "claim_expired", ExpiresAt: "2018-06-26T07:00:00Z"},
+		{ID: "claim_used", ExpiresAt: "2018-06-27T08:00:00Z", ClaimedAt: "2018-06-26T07:00:00Z", KeyID: "key1"},
+		{ID: "claim_race", ExpiresAt: "2018-06-27T08:00:00Z"},
+		{ID: "claim_lost", ExpiresAt: "2018-06-27T08:00:00Z"},
+	}
+	for _, claim := range claims {
+		claim.SecretHash, claim.TenantID, claim.Serial, claim.DeviceModel, claim.DeviceID = auth.HashSecret("secret"), "tenant_test", "SN-1", "thermo-2", "d_" + claim.ID
+		fake.items[PROVISIONING_TABLE_NAME][claim.ID], _ = dynamodbattribute.MarshalMap(claim)
+	}
+	return fake
+}
+
+// a mocked version of DynamoDB's GetItem function, for the provisioning table
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	if *input.Key["id"].S == "claim_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	return &dynamodb.GetItemOutput{Item: fd.items[*input.TableName][*input.Key["id"].S]}, nil
+}
+
+// a mocked version of DynamoDB's PutItem function, for devices and api keys tables. items are only inserted once
+func (fd *FakeDynamoDBAPI) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if _, ok := fd.items[*input.TableName][*input.Item["id"].S]; ok {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	fd.items[*input.TableName][*input.Item["id"].S] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// a mocked version of DynamoDB's UpdateItem function, it redeems claims and revokes api keys
+func (fd *FakeDynamoDBAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	item := fd.items[*input.TableName][*input.Key["id"].S]
+	values := input.ExpressionAttributeValues
+	if *input.TableName == KEYS_TABLE_NAME {
+		item["revoked"] = values[":revoked"]
+		return &dynamodb.UpdateItemOutput{}, nil
+	}
+	sameKey := item["keyId"] != nil && *item["keyId"].S == *values[":keyId"].S
+	if *input.Key["id"].S == "claim_race" || (item["claimedAt"] != nil && !sameKey) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	if item["claimedAt"] == nil {
+		item["claimedAt"] = values[":now"]
+	}
+	item["keyId"] = values[":keyId"]
+
+	// the first redeem of "claim_lost" is stored but its response is lost, so it's retried
+	if *input.Key["id"].S == "claim_lost" && !sameKey {
+		return nil, awserr.NewRequestFailure(awserr.New("InternalServerError", "Internal server error", nil), 500, "request_lost")
+	}
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+// handler of tests, devices are provisioned at a fixed time
+func newTestHandler(fake *FakeDynamoDBAPI) apigw.Handler {
+	cfg := config.Default()
+	cfg.DevicesTableName = DEVICES_TABLE_NAME
+	cfg.ApiKeysTableName = KEYS_TABLE_NAME
+	cfg.ProvisioningTableName = PROVISIONING_TABLE_NAME
+	services := &apigw.Services{
+		Config:		cfg,
+		DynamoDB:	fake,
+		Keys:		auth.NewKeyStore(fake, cfg.ApiKeysTableName, retry.Default),
+		Retry:		retry.Default,
+	}
+
+	now := time.Unix(1530000000, 0)
+	store := &provisioning.Store{DynamoDB: fake, TableName: aws.String(PROVISIONING_TABLE_NAME), Retry: retry.Default}
+	provision := &dynamoDBAPI{DynamoDB: fake, TableName: aws.String(DEVICES_TABLE_NAME), Retry: retry.Default, Now: func() time.Time { return now }, Claims: store, Keys: services.Keys}
+	return apigw.Chain(provision.Provision, apigw.Recover(), apigw.ErrorMapping(), apigw.RequireConfig(services))
+}
+
+func provisionRequest(serial string, token string) events.APIGatewayProxyRequest {
+	body, _ := json.Marshal(types.ProvisionRequest{Serial: serial, Token: token})
+	return events.APIGatewayProxyRequest{HTTPMethod: "POST", Body: string(body)}
+}
+
+func TestProvision(t *testing.T) {
+
+	errorBody := func(code int, message string) string {
+		return types.NewErrorResponseJson(code, message)
+	}
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing wrong secret **",
+			InputRequest:		provisionRequest("SN-1", "claim1.wrong"),
+			ExpectedBody:		errorBody(401, "Invalid claim token"),
+			ExpectedStatusCode:	401,
+		},
+		{
+			Name:				"** Testing serial of another claim **",
+			InputRequest:		provisionRequest("SN-2", "claim1.secret"),
+			ExpectedBody:		errorBody(401, "Invalid claim token"),
+			ExpectedStatusCode:	401,
+		},
+		{
+			Name:				"** Testing unknown claim **",
+			InputRequest:		provisionRequest("SN-1", "claim2.secret"),
+			ExpectedBody:		errorBody(401, "Invalid claim token"),
+			ExpectedStatusCode:	401,
+		},
+		{
+			Name:				"** Testing malformed token **",
+			InputRequest:		provisionRequest("SN-1", "claim1"),
+			ExpectedBody:		errorBody(401, "Invalid claim token"),
+			ExpectedStatusCode:	401,
+		},
+		{
+			Name:				"** Testing expired claim **",
+			InputRequest:		provisionRequest("SN-1", "claim_expired.secret"),
+			ExpectedBody:		errorBody(401, "Claim token is expired, please ask for a new one"),
+			ExpectedStatusCode:	401,
+		},
+		{
+			Name:				"** Testing used claim **",
+			InputRequest:		provisionRequest("SN-1", "claim_used.secret"),
+			ExpectedBody:		errorBody(409, "Claim token is already used"),
+			ExpectedStatusCode:	409,
+		},
+		{
+			Name:				"** Testing claim that is redeemed concurrently **",
+			InputRequest:		provisionRequest("SN-1", "claim_race.secret"),
+			ExpectedBody:		errorBody(409, "Claim token is already used"),
+			ExpectedStatusCode:	409,
+		},
+		{
+			Name:				"** Testing missing serial **",
+			InputRequest:		events.APIGatewayProxyRequest{HTTPMethod: "POST", Body: "{\"token\": \"claim1.secret\"}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Provision request doesn't match its schema /schemas/provision.json\",\n\t\t\"violations\": [\n\t\t\t{\n\t\t\t\t\"pointer\": \"/serial\",\n\t\t\t\t\"message\": \"is required\"\n\t\t\t}\n\t\t]\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		provisionRequest("SN-1", "claim_error.secret"),
+			ExpectedBody:		errorBody(500, "Internal Server's Error occured"),
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases
+		fake := newFakeDynamoDBAPI()
+		handler := newTestHandler(fake)
+
+		// calls provision.go's Provision function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("POST", "/provision", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+
+		// keys of calls that lost the claim are revoked
+		for id, key := range fake.items[KEYS_TABLE_NAME] {
+			if key["revoked"] == nil || !*key["revoked"].BOOL {
+				t.Errorf("%s \n \t<api key %s of a failed call is not revoked>", test.Name, id)
+			}
+		}
+	}
+
+} // end of TestProvision function
+
+func TestProvisionedCredential(t *testing.T) {
+
+	// keys are random, so the credential is checked against what is stored
+	fake := newFakeDynamoDBAPI()
+	handler := newTestHandler(fake)
+	response, _ := handler(context.Background(), provisionRequest("SN-1", "claim1.secret"))
+
+	for _, problem := range api.CheckResponse("POST", "/provision", response) {
+		t.Errorf("** Testing provisioned device ** \n \t<response drifted from the document: %s>", problem)
+	}
+
+	provisioned := types.ProvisionResponse{}
+	json.Unmarshal([]byte(response.Body), &provisioned)
+	if response.StatusCode != 201 || provisioned.Status != "device provisioned" || provisioned.Device.DeviceID != "d_claim1" || provisioned.Device.TenantID != "tenant_test" {
+		t.Fatalf("** Testing provisioned device ** \n \t<expected device: d_claim1> <resulted error-code: %d> <resulted body: %s>", response.StatusCode, response.Body)
+	}
+
+	device := types.Device{}
+	dynamodbattribute.UnmarshalMap(fake.items[DEVICES_TABLE_NAME]["d_claim1"], &device)
+	if device.Serial != "SN-1" || device.DeviceModel != "thermo-2" || device.Status != types.STATUS_PROVISIONED || *fake.items[DEVICES_TABLE_NAME]["d_claim1"]["tenantId"].S != "tenant_test" {
+		t.Errorf("** Testing inserted device ** \n \t<resulted device: %+v>", device)
+	}
+
+	// the key authenticates on routes of the device only
+	keys := auth.NewKeyStore(fake, KEYS_TABLE_NAME, retry.Default)
+	own := events.APIGatewayProxyRequest{Resource: "/devices/{id}/heartbeat", PathParameters: map[string]string{"id": "d_claim1"}, Headers: map[string]string{"X-Api-Key": provisioned.Device.Key}}
+	if principal, denied := keys.AuthenticateTenant(context.Background(), own, auth.SCOPE_DEVICES_WRITE); denied != nil || principal.ID != provisioned.Device.KeyID || principal.DeviceID != "d_claim1" {
+		t.Errorf("** Testing credential on route of the device ** \n \t<resulted principal: %+v> <resulted response: %v>", principal, denied)
+	}
+	other := events.APIGatewayProxyRequest{Resource: "/devices/{id}", PathParameters: map[string]string{"id": "d_other"}, Headers: map[string]string{"X-Api-Key": provisioned.Device.Key}}
+	if _, denied := keys.AuthenticateTenant(context.Background(), other, auth.SCOPE_DEVICES_READ); denied == nil || denied.StatusCode != 403 {
+		t.Errorf("** Testing credential on route of another device ** \n \t<expected error-code: 403> <resulted response: %v>", denied)
+	}
+
+	// the claim can't be used again
+	again, _ := handler(context.Background(), provisionRequest("SN-1", "claim1.secret"))
+	if again.StatusCode != 409 || *fake.items[PROVISIONING_TABLE_NAME]["claim1"]["keyId"].S != provisioned.Device.KeyID {
+		t.Errorf("** Testing claim used again ** \n \t<expected error-code: 409> <resulted error-code: %d> <resulted body: %s>", again.StatusCode, again.Body)
+	}
+
+} // end of TestProvisionedCredential function
+
+func TestProvisionRetriedRedeem(t *testing.T) {
+
+	// the redeem is stored by its first attempt, the retry of the same key must not take the credential away
+	fake := newFakeDynamoDBAPI()
+	response, _ := newTestHandler(fake)(context.Background(), provisionRequest("SN-1", "claim_lost.secret"))
+
+	provisioned := types.ProvisionResponse{}
+	json.Unmarshal([]byte(response.Body), &provisioned)
+	key := fake.items[KEYS_TABLE_NAME][provisioned.Device.KeyID]
+	if response.StatusCode != 201 || key == nil || *key["revoked"].BOOL || *fake.items[PROVISIONING_TABLE_NAME]["claim_lost"]["keyId"].S != provisioned.Device.KeyID {
+		t.Errorf("** Testing redeem with a lost response ** \n \t<expected error-code: 201> <resulted error-code: %d> <resulted body: %s>", response.StatusCode, response.Body)
+	}
+
+} // end of TestProvisionRetriedRedeem function
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"policy"
+	"provisioning"
+	"localserver"
+	"metrics"
+	"types"
+	"fmt"
+	"time"
+	"context"
+	"strings"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// reasons of rejected inputs, they are Reason dimension of ValidationFailures metric
+const REASON_EMPTY_BODY = "empty_body"
+const REASON_INVALID_JSON = "invalid_json"
+const REASON_SCHEMA_VIOLATION = "schema_violation"
+
+type SuccessResponse = types.ClaimsResponse
+
+// provisioning store of the handler, it's built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	Now func() time.Time
+	Claims *provisioning.Store
+}
+
+// main AWS lambda function starting point.
+// It pre-registers serials of a device model in caller's tenant. Every serial gets a one-time claim token that is only
+// returned here, the admin ships it with the device and the device exchanges it for its id and credential by POST /provision.
+func (ig *dynamoDBAPI) ProvisioningClaims(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:write scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+	if denied := policy.Check(principal, policy.PERMISSION_DEVICES_PROVISION); denied != nil {
+		return *denied, nil
+	}
+
+	claimsRequest, reason, err := validateClaims(request)
+	if err != nil {
+		metrics.ValidationFailure(ctx, reason)
+		return events.APIGatewayProxyResponse{
+			Body:	err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	expiresIn := claimsRequest.ExpiresInHours
+	if expiresIn == 0 {
+		expiresIn = types.DEFAULT_CLAIM_EXPIRES_IN_HOURS
+	}
+	now := ig.Now()
+	expiresAt := now.Add(time.Duration(expiresIn) * time.Hour)
+
+	// a failure leaves the claims stored before it, they are unknown to the admin and expire unused
+	issued := []types.IssuedClaim{}
+	for _, serial := range claimsRequest.Serials {
+		token, claim, err := provisioning.NewClaim(principal.TenantID, claimsRequest.DeviceModel, serial, now, expiresAt)
+		if err == nil {
+			err = ig.Claims.Put(ctx, claim)
+		}
+		if err != nil {
+			return events.APIGatewayProxyResponse{}, err
+		}
+		issued = append(issued, claim.Issued(token))
+	}
+
+	successResponseJson, _ := json.MarshalIndent(&SuccessResponse{Status: "claims issued", Claims: issued}, "", "\t")
+	return events.APIGatewayProxyResponse{
+		Body:	string(successResponseJson),
+		StatusCode: 201,
+	}, nil
+}
+
+// validateClaims returns the claims request of the body, or reason and error body of rejecting it.
+// The body is validated against the claims schema (GET /schemas/claims.json).
+func validateClaims(request events.APIGatewayProxyRequest) (types.ClaimsRequest, string, error) {
+	claimsRequest := types.ClaimsRequest{}
+	if len(strings.TrimSpace(request.Body)) == 0 {
+		return claimsRequest, REASON_EMPTY_BODY, errors.New(createErrorResponseJson(400, "No inputs provided, please provide inputs in json format."))
+	}
+
+	var body interface{}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return claimsRequest, REASON_INVALID_JSON, errors.New(createErrorResponseJson(400, "Wrong format: Inputs must be a valid json."))
+	}
+
+	if violations := api.ValidateClaims(body); len(violations) != 0 {
+		errorMessage := "Claims don't match their schema " + api.SCHEMAS_PATH + "claims.json"
+		return claimsRequest, REASON_SCHEMA_VIOLATION, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
+	}
+	json.Unmarshal([]byte(request.Body), &claimsRequest)
+	return claimsRequest, "", nil
+}
+
+func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
+	return types.NewErrorResponseJson(errorCode, errorMessage)
+}
+
+// newHandler wraps ProvisioningClaims with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services. The provisioning table is only needed by provisioning handlers, so it's checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	if len(services.Config.ProvisioningTableName) == 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: []string{"PROVISIONING_TABLE_NAME is not set"}}
+	}
+	store := &provisioning.Store{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.ProvisioningTableName), Retry: services.Retry}
+	claims := &dynamoDBAPI{Now: time.Now, Claims: store}
+	return apigw.Chain(claims.ProvisioningClaims, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("provisioningClaims")...)
+}
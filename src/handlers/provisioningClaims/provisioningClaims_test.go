@@ -0,0 +1,145 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"provisioning"
+	"retry"
+	"types"
+	"testing"
+	"context"
+	"time"
+	"errors"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+}
+
+// A fakeDynamoDB instance for mocking test that emulates the provisioning table, it keeps claims by their id.
+// Claims of serial "SN-error" fail.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	items	map[string]map[string]*dynamodb.AttributeValue
+}
+
+// a mocked version of DynamoDB's PutItem function
+func (fd *FakeDynamoDBAPI) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if *input.Item["serial"].S == "SN-error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	fd.items[*input.Item["id"].S] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// handler of tests, claims are issued at a fixed time
+func newTestHandler(fake *FakeDynamoDBAPI) apigw.Handler {
+	now := time.Unix(1530000000, 0)
+	store := &provisioning.Store{DynamoDB: fake, TableName: aws.String("test_provisioning_table_name"), Retry: retry.Default}
+	claims := &dynamoDBAPI{Now: func() time.Time { return now }, Claims: store}
+	return apigw.Chain(claims.ProvisioningClaims, apigw.Standard(apigwtest.NewServices(fake, nil), auth.SCOPE_DEVICES_WRITE)...)
+}
+
+func TestProvisioningClaims(t *testing.T) {
+
+	claims := func(key string, body string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: map[string]string{"X-Api-Key": key}, Body: body}
+	}
+	errorBody := func(code int, message string) string {
+		return types.NewErrorResponseJson(code, message)
+	}
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing duplicate serials **",
+			InputRequest:		claims(apigwtest.ADMIN_API_KEY, "{\"deviceModel\": \"thermo-2\", \"serials\": [\"SN-1\", \"SN-1\"]}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Claims don't match their schema /schemas/claims.json\",\n\t\t\"violations\": [\n\t\t\t{\n\t\t\t\t\"pointer\": \"/serials/1\",\n\t\t\t\t\"message\": \"is a duplicate of a previous serial\"\n\t\t\t}\n\t\t]\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing null serials **",
+			InputRequest:		claims(apigwtest.ADMIN_API_KEY, "{\"deviceModel\": \"thermo-2\", \"serials\": null}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Claims don't match their schema /schemas/claims.json\",\n\t\t\"violations\": [\n\t\t\t{\n\t\t\t\t\"pointer\": \"/serials\",\n\t\t\t\t\"message\": \"must be array\"\n\t\t\t}\n\t\t]\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing empty body **",
+			InputRequest:		claims(apigwtest.ADMIN_API_KEY, ""),
+			ExpectedBody:		errorBody(400, "No inputs provided, please provide inputs in json format."),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		claims(apigwtest.ADMIN_API_KEY, "{\"deviceModel\": \"thermo-2\", \"serials\": [\"SN-error\"]}"),
+			ExpectedBody:		errorBody(500, "Internal Server's Error occured"),
+			ExpectedStatusCode:	500,
+		},
+		{
+			Name:				"** Testing operator pre-registering serials **",
+			InputRequest:		claims(apigwtest.OPERATOR_API_KEY, "{\"deviceModel\": \"thermo-2\", \"serials\": [\"SN-1\"]}"),
+			ExpectedBody:		errorBody(403, "Operation is not permitted: none of roles [operator] grants devices:provision"),
+			ExpectedStatusCode:	403,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked database
+		handler := newTestHandler(&FakeDynamoDBAPI{items: map[string]map[string]*dynamodb.AttributeValue{}})
+
+		// calls provisioningClaims.go's ProvisioningClaims function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("POST", "/provisioning/claims", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode || response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+
+} // end of TestProvisioningClaims function
+
+func TestIssuedTokens(t *testing.T) {
+
+	// tokens are random, so issued claims are checked against what is stored
+	fake := &FakeDynamoDBAPI{items: map[string]map[string]*dynamodb.AttributeValue{}}
+	request := events.APIGatewayProxyRequest{HTTPMethod: "POST", Headers: map[string]string{"X-Api-Key": apigwtest.ADMIN_API_KEY}, Body: "{\"deviceModel\": \"thermo-2\", \"serials\": [\"SN-1\", \"SN-2\"], \"expiresInHours\": 24}"}
+	response, _ := newTestHandler(fake)(context.Background(), request)
+
+	for _, problem := range api.CheckResponse("POST", "/provisioning/claims", response) {
+		t.Errorf("** Testing issued claims ** \n \t<response drifted from the document: %s>", problem)
+	}
+
+	issued := types.ClaimsResponse{}
+	json.Unmarshal([]byte(response.Body), &issued)
+	if response.StatusCode != 201 || issued.Status != "claims issued" || len(issued.Claims) != 2 || len(fake.items) != 2 {
+		t.Fatalf("** Testing issued claims ** \n \t<expected claims: 2> <resulted error-code: %d> <resulted body: %s>", response.StatusCode, response.Body)
+	}
+
+	for i, claim := range issued.Claims {
+		stored := provisioning.Claim{}
+		dynamodbattribute.UnmarshalMap(fake.items[claim.ID], &stored)
+		_, secret := provisioning.SplitToken(claim.Token)
+		if claim.Serial != []string{"SN-1", "SN-2"}[i] || stored.TenantID != "tenant_test" || stored.DeviceID != claim.DeviceID ||
+			claim.ExpiresAt != "2018-06-27T08:00:00Z" || stored.Verify(claim.Serial, secret, time.Unix(1530000000, 0)) != nil {
+			t.Errorf("** Testing issued claim ** \n \t<issued claim: %+v> <stored claim: %+v>", claim, stored)
+		}
+	}
+
+} // end of TestIssuedTokens function
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"config"
+	"localserver"
+	"fmt"
+	"context"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// main AWS lambda function starting point.
+// It returns a JSON Schema of api.Schemas by name, e.g. GET /schemas/device.json.
+// Handlers validate request bodies against the same schemas.
+func Schemas(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	name := request.PathParameters["name"]
+	generate, ok := api.Schemas[name]
+	if !ok {
+		return apigw.ErrorResponse(404, "Schema not found: " + name), nil
+	}
+	response := apigw.JSONResponse(200, generate())
+	apigw.SetHeader(&response, "Content-Type", "application/schema+json")
+	return response, nil
+}
+
+// newHandler wraps Schemas with the shared middlewares that don't need a valid configuration,
+// schemas are public and aren't rate limited.
+func newHandler(services *apigw.Services) apigw.Handler {
+	return apigw.Chain(Schemas,
+		apigw.Logging(),
+		apigw.Trace(services.Tracer),
+		apigw.Measure(services.Metrics),
+		apigw.CORS(services.Config.CORSAllowedOrigin),
+		apigw.Recover(),
+	)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("schemas")...)
+}
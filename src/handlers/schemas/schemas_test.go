@@ -0,0 +1,60 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"config"
+	"types"
+	"testing"
+	"context"
+	"strings"
+	"github.com/aws/aws-lambda-go/events"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 					string
+	Request 				events.APIGatewayProxyRequest
+	ExpectedBody 			[]string // parts that body must contain
+	ExpectedStatusCode 		int
+}
+
+func TestSchemas(t *testing.T) {
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing device schema **",
+			Request:			events.APIGatewayProxyRequest{HTTPMethod: "GET", PathParameters: map[string]string{"name": "device.json"}},
+			ExpectedBody:		[]string{"\"$id\": \"/schemas/device.json\"", "\"version\": \"" + types.DEVICE_SCHEMA_VERSION + "\"", "\"minLength\": 1"},
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing unknown schema **",
+			Request:			events.APIGatewayProxyRequest{HTTPMethod: "GET", PathParameters: map[string]string{"name": "sensor.json"}},
+			ExpectedBody:		[]string{"Schema not found: sensor.json"},
+			ExpectedStatusCode:	404,
+		},
+	}
+
+	// schemas are public, so the handler works without keys and database
+	handler := newHandler(&apigw.Services{Config: config.Default()})
+
+	for _, test := range testCases {
+
+		response, _ := handler(context.Background(), test.Request)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("GET", "/schemas/{name}", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode {
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, response.Body)
+		}
+		for _, part := range test.ExpectedBody {
+			if !strings.Contains(response.Body, part) {
+				t.Errorf("%s \n \t<expected body part: %s> <resulted body: %s>", test.Name, part, response.Body)
+			}
+		}
+	}
+} // end of TestSchemas function
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"devices"
+	"policy"
+	"localserver"
+	"metrics"
+	"retry"
+	"schema"
+	"tracing"
+	"types"
+	"fmt"
+	"context"
+	"sort"
+	"strings"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// fields of types.Device that can be changed, id and tenant of a device never change
+var UPDATABLE_FIELDS = []string{"attributes", "deviceModel", "name", "note", "serial", "tags"}
+
+var ErrDeviceNotFound = errors.New("device not found")
+var ErrDeviceRetired = errors.New("device is retired")
+
+// reasons of rejected inputs, they are Reason dimension of ValidationFailures metric
+const REASON_EMPTY_BODY = "empty_body"
+const REASON_INVALID_JSON = "invalid_json"
+const REASON_SCHEMA_VIOLATION = "schema_violation"
+const REASON_INVALID_ATTRIBUTES = "invalid_attributes"
+
+type SuccessResponse = types.DeviceResponse
+
+// devices table of the handler, it's built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Models map[string]types.DeviceModel	// attribute definitions of device models
+}
+
+// main AWS lambda function starting point.
+// It gets some fields of a device from client as json and only changes those fields of the device.
+// Each changed field needs its own permission, e.g. operators can change note but not serial.
+func (ig *dynamoDBAPI) UpdateDevice(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:write scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+
+	id := request.PathParameters["id"]
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "No ID Field Provided"),
+			StatusCode: 404,
+		}, nil
+	}
+
+	_, span := tracing.Start(ctx, "validate request")
+	fields, reason, err := validateInputs(request)
+	span.SetAttribute("validation.reason", reason)
+	span.Finish()
+	if err != nil {
+		metrics.ValidationFailure(ctx, reason)
+		return events.APIGatewayProxyResponse{
+			Body:	err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	// caller's roles must allow changing every requested field
+	if denied := policy.Check(principal, policy.UpdatePermissions(sortedKeys(fields))...); denied != nil {
+		return *denied, nil
+	}
+
+	// attributes of the updated device must still match definitions of its model
+	violations, err := ig.validateAttributes(ctx, principal.TenantID, id, fields)
+	if err == nil && len(violations) != 0 {
+		metrics.ValidationFailure(ctx, REASON_INVALID_ATTRIBUTES)
+		errorMessage := "Attributes don't match definitions of device model"
+		return events.APIGatewayProxyResponse{
+			Body:	types.NewViolationsResponseJson(400, errorMessage, violations),
+			StatusCode: 400,
+		}, nil
+	}
+
+	var device types.Device
+	if err == nil {
+		device, err = ig.updateItemInDatabase(ctx, principal.TenantID, id, fields)
+	}
+	if err == ErrDeviceNotFound {
+		devices.LogCrossTenantAccess(ctx, ig.DynamoDB, ig.TableName, principal, id)
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Desired device with provided id was not founded"),
+			StatusCode: 404,
+		}, nil
+	}
+	if err == ErrDeviceRetired {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, "Retired devices can't be changed"),
+			StatusCode: 409,
+		}, nil
+	}
+	if err != nil {
+		return events.APIGatewayProxyResponse{}, err
+	}
+
+	_, span = tracing.Start(ctx, "serialize response")
+	successResponseJson, _ := json.MarshalIndent(&SuccessResponse{Status: "requested item updated", Device: device}, "", "\t")
+	span.Finish()
+	return events.APIGatewayProxyResponse{
+		Body:	string(successResponseJson),
+		StatusCode: 200,
+	}, nil
+}
+
+// validateInputs returns requested fields and their new values, or reason and error body of rejecting them.
+// body is validated against the device schema (GET /schemas/device.json) with optional properties, and every
+// violation is reported, fields that can't be updated (id) are violations too.
+// attributes and tags replace all attributes or tags of the device, null removes them.
+func validateInputs(request events.APIGatewayProxyRequest) (map[string]interface{}, string, error) {
+
+	if len(request.Body) == 0 {
+		return nil, REASON_EMPTY_BODY, errors.New(createErrorResponseJson(400, "No inputs provided, please provide inputs in json format."))
+	}
+
+	var body interface{}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return nil, REASON_INVALID_JSON, errors.New(createErrorResponseJson(400, "Wrong format: Inputs must be a valid json."))
+	}
+
+	violations := api.ValidateDevice(body, true)
+	object, _ := body.(map[string]interface{})
+	fields := map[string]interface{}{}
+	for _, name := range sortedKeys(object) {
+		if name == "id" {
+			violations = append(violations, schema.Violation{Pointer: "/id", Message: "can not be updated, updatable fields are " + strings.Join(UPDATABLE_FIELDS, ", ")})
+		} else if name == "status" {
+			violations = append(violations, schema.Violation{Pointer: "/status", Message: "can not be updated, statuses are changed by POST /devices/{id}:transition"})
+		} else if (name == "attributes" || name == "tags") && object[name] == nil {
+			fields[name] = map[string]interface{}{}
+		} else if isUpdatable(name) {
+			fields[name] = object[name]
+		}
+	}
+
+	if len(violations) != 0 {
+		errorMessage := "Device doesn't match its schema " + api.SCHEMAS_PATH + "device.json"
+		return nil, REASON_SCHEMA_VIOLATION, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
+	}
+	return fields, "", nil
+}
+
+func isUpdatable(name string) bool {
+	for _, field := range UPDATABLE_FIELDS {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
+
+// keys of a map in a fixed order, so expressions and messages are deterministic
+func sortedKeys(values interface{}) []string {
+	keys := []string{}
+	switch m := values.(type) {
+	case map[string]interface{}:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
+	return types.NewErrorResponseJson(errorCode, errorMessage)
+}
+
+// validateAttributes returns violations of the updated device's attributes against definitions of its model.
+// when only one of deviceModel and attributes is changed, the other one is read from the stored device.
+func (ig *dynamoDBAPI) validateAttributes(ctx context.Context, tenantId string, id string, fields map[string]interface{}) ([]schema.Violation, error) {
+	deviceModel, changesModel := fields["deviceModel"].(string)
+	attributes, changesAttributes := fields["attributes"].(map[string]interface{})
+	if len(ig.Models) == 0 || (!changesModel && !changesAttributes) {
+		return nil, nil
+	}
+
+	if !changesModel || !changesAttributes {
+		current, err := ig.getItemFromDatabase(ctx, tenantId, id)
+		if err != nil {
+			return nil, err
+		}
+		if !changesModel {
+			deviceModel = current.DeviceModel
+		}
+		if !changesAttributes {
+			attributes = current.Attributes
+		}
+	}
+	return api.ValidateAttributes(ig.Models, deviceModel, attributes), nil
+}
+
+// function that returns a device of the tenant, ErrDeviceNotFound when it doesn't exist
+func (ig *dynamoDBAPI) getItemFromDatabase(ctx context.Context, tenantId string, id string) (types.Device, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: ig.TableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	var output *dynamodb.GetItemOutput
+	err := ig.Retry.Do(ctx, func() (err error) {
+		output, err = ig.DynamoDB.GetItemWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return types.Device{}, err
+	}
+	if len(output.Item) == 0 {
+		return types.Device{}, ErrDeviceNotFound
+	}
+
+	device := types.Device{}
+	err = dynamodbattribute.UnmarshalMap(output.Item, &device)
+	return device, err
+}
+
+// function that only changes provided fields of an existing device of the tenant and returns the updated device.
+// attributes are stored as a native map (M), like addDevice stores them. retired devices are not changed, the
+// failed condition is told apart from a missing device by reading the device again.
+func (ig *dynamoDBAPI) updateItemInDatabase(ctx context.Context, tenantId string, id string, fields map[string]interface{}) (types.Device, error) {
+
+	names := map[string]*string{"#status": aws.String("status")}
+	values := map[string]*dynamodb.AttributeValue{":retired": {S: aws.String(types.STATUS_RETIRED)}}
+	assignments := []string{}
+	for i, name := range sortedKeys(fields) {
+		names[fmt.Sprintf("#f%d", i)] = aws.String(name)
+		value, err := dynamodbattribute.Marshal(fields[name])
+		if err != nil {
+			return types.Device{}, err
+		}
+		values[fmt.Sprintf(":v%d", i)] = value
+		assignments = append(assignments, fmt.Sprintf("#f%d = :v%d", i, i))
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: ig.TableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		UpdateExpression: aws.String("SET " + strings.Join(assignments, ", ")),
+		ConditionExpression: aws.String("attribute_exists(id) AND (attribute_not_exists(#status) OR #status <> :retired)"),
+		ExpressionAttributeNames: names,
+		ExpressionAttributeValues: values,
+		ReturnValues: aws.String(dynamodb.ReturnValueAllNew),
+	}
+
+	var output *dynamodb.UpdateItemOutput
+	err := ig.Retry.Do(ctx, func() (err error) {
+		output, err = ig.DynamoDB.UpdateItemWithContext(ctx, input)
+		return err
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		if _, err := ig.getItemFromDatabase(ctx, tenantId, id); err != nil {
+			return types.Device{}, err
+		}
+		return types.Device{}, ErrDeviceRetired
+	}
+	if err != nil {
+		return types.Device{}, err
+	}
+
+	device := types.Device{}
+	err = dynamodbattribute.UnmarshalMap(output.Attributes, &device)
+	return device, err
+}
+
+// newHandler wraps UpdateDevice with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services.
+func newHandler(services *apigw.Services) apigw.Handler {
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Models: services.Config.DeviceModels}
+	return apigw.Chain(table.UpdateDevice, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("updateDevice")...)
+}
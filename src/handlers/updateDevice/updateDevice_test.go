@@ -0,0 +1,242 @@
+package main
+
+import(
+	"api"
+	"apigwtest"
+	"schema"
+	"types"
+	"testing"
+	"context"
+	"strings"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// A fakeDynamoDB instance for mocking test that emulates real DynamoDB
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+}
+
+// a mocked version of DynamoDB's Query function on the id index, it's used for logging cross-tenant access
+// attempts and devices of other tenants aren't known
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{}, nil
+}
+
+// message of bodies that violate the device schema
+const SCHEMA_MESSAGE = "Device doesn't match its schema /schemas/device.json"
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 				string
+	Request 			events.APIGatewayProxyRequest
+	ExpectedBody 		string
+	ExpectedStatusCode 	int
+}
+
+// a mocked version of DynamoDB's UpdateItem function, only "id_test" of "tenant_test" exists.
+func (fd *FakeDynamoDBAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	if *input.Key["tenantId"].S != "tenant_test" || *input.Key["id"].S != "id_test" {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+
+	attributes := map[string]*dynamodb.AttributeValue{
+		"id": &dynamodb.AttributeValue{S: aws.String("id_test")},
+		"tenantId": &dynamodb.AttributeValue{S: aws.String("tenant_test")},
+		"deviceModel": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+		"name": &dynamodb.AttributeValue{S: aws.String("name_test")},
+		"note": &dynamodb.AttributeValue{S: aws.String("note_test")},
+		"serial": &dynamodb.AttributeValue{S: aws.String("serial_test")},
+	}
+	for placeholder, name := range input.ExpressionAttributeNames {
+		if strings.HasPrefix(placeholder, "#f") {
+			attributes[*name] = input.ExpressionAttributeValues[":v" + placeholder[2:]]
+		}
+	}
+
+	return &dynamodb.UpdateItemOutput{Attributes: attributes}, nil
+}
+
+// a mocked version of DynamoDB's GetItem function, "id_test" of "tenant_test" has a samplingRate attribute
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	output := new(dynamodb.GetItemOutput)
+	if *input.Key["tenantId"].S == "tenant_test" && *input.Key["id"].S == "id_test" {
+		output.SetItem(map[string]*dynamodb.AttributeValue{
+			"id": &dynamodb.AttributeValue{S: aws.String("id_test")},
+			"deviceModel": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+			"attributes": &dynamodb.AttributeValue{M: map[string]*dynamodb.AttributeValue{"samplingRate": {N: aws.String("1")}}},
+		})
+	}
+	if *input.Key["tenantId"].S == "tenant_test" && *input.Key["id"].S == "id_retired" {
+		output.SetItem(map[string]*dynamodb.AttributeValue{
+			"id": &dynamodb.AttributeValue{S: aws.String("id_retired")},
+			"deviceModel": &dynamodb.AttributeValue{S: aws.String("deviceModel_test")},
+			"status": &dynamodb.AttributeValue{S: aws.String(types.STATUS_RETIRED)},
+		})
+	}
+	return output, nil
+}
+
+func TestUpdateDevice(t *testing.T) {
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing missing api key **",
+			Request:			events.APIGatewayProxyRequest{Headers: map[string]string{}, PathParameters: map[string]string{"id": "id_test"}, Body: "{\"note\":\"new note\"}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 401,\n\t\t\"message\": \"No API key provided\"\n\t}\n}",
+			ExpectedStatusCode:	401,
+		},
+		{
+			Name:				"** Testing not updatable fields **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"id\":\"id2\",\"tenantId\":\"other\"}"},
+			ExpectedBody:		types.NewViolationsResponseJson(400, SCHEMA_MESSAGE, []schema.Violation{
+				{Pointer: "/tenantId", Message: "is not allowed"},
+				{Pointer: "/id", Message: "can not be updated, updatable fields are attributes, deviceModel, name, note, serial, tags"},
+			}),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing status is only changed by transitions **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"status\":\"active\"}"},
+			ExpectedBody:		types.NewViolationsResponseJson(400, SCHEMA_MESSAGE, []schema.Violation{
+				{Pointer: "/status", Message: "can not be updated, statuses are changed by POST /devices/{id}:transition"},
+			}),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing empty field **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"note\":\"\"}"},
+			ExpectedBody:		types.NewViolationsResponseJson(400, SCHEMA_MESSAGE, []schema.Violation{{Pointer: "/note", Message: "must not be empty"}}),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing no fields **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{}"},
+			ExpectedBody:		types.NewViolationsResponseJson(400, SCHEMA_MESSAGE, []schema.Violation{{Pointer: "", Message: "must not be empty"}}),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing operator changing serial **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"note\":\"new note\",\"serial\":\"new serial\"}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 403,\n\t\t\"message\": \"Operation is not permitted: none of roles [operator] grants devices:update:serial\"\n\t}\n}",
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name:				"** Testing operator changing note **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"note\":\"new note\"}"},
+			ExpectedBody:		"{\n\t\"status\": \"requested item updated\",\n\t\"data\": {\n\t\t\"id\": \"id_test\",\n\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\"name\": \"name_test\",\n\t\t\"note\": \"new note\",\n\t\t\"serial\": \"serial_test\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing admin changing serial **",
+			Request:			events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.ADMIN_API_KEY}, PathParameters: map[string]string{"id": "id_test"}, Body: "{\"serial\":\"new serial\"}"},
+			ExpectedBody:		"{\n\t\"status\": \"requested item updated\",\n\t\"data\": {\n\t\t\"id\": \"id_test\",\n\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\"name\": \"name_test\",\n\t\t\"note\": \"note_test\",\n\t\t\"serial\": \"new serial\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing not existing device **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test_no"}, Body: "{\"note\":\"new note\"}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Desired device with provided id was not founded\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing null tags and attributes remove them **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"tags\":null,\"attributes\":null}"},
+			ExpectedBody:		"{\n\t\"status\": \"requested item updated\",\n\t\"data\": {\n\t\t\"id\": \"id_test\",\n\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\"name\": \"name_test\",\n\t\t\"note\": \"note_test\",\n\t\t\"serial\": \"serial_test\"\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing retired device **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_retired"}, Body: "{\"note\":\"new note\"}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 409,\n\t\t\"message\": \"Retired devices can't be changed\"\n\t}\n}",
+			ExpectedStatusCode:	409,
+		},
+	}
+
+	// create mocked databases.
+	handler := newHandler(apigwtest.NewServices(&FakeDynamoDBAPI{}, nil))
+
+	for _, test := range testCases {
+
+		// requests without explicit headers are sent with an operator key
+		if test.Request.Headers == nil {
+			test.Request.Headers = map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}
+		}
+
+		response, _ := handler(context.Background(), test.Request)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("PATCH", "/devices/{id}", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // end of TestUpdateDevice function
+
+func TestUpdateDeviceAttributes(t *testing.T) {
+
+	services := apigwtest.NewServices(&FakeDynamoDBAPI{}, nil)
+	services.Config.DeviceModels = map[string]types.DeviceModel{
+		"deviceModel_test":	{Attributes: map[string]schema.Schema{"samplingRate": {"type": "number"}}, Required: []string{"samplingRate"}},
+		"cameraModel":		{Attributes: map[string]schema.Schema{"resolution": {"type": "string"}}, Required: []string{"resolution"}},
+	}
+	handler := newHandler(services)
+
+	attributesMessage := "Attributes don't match definitions of device model"
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing operator changing attributes **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"attributes\":{\"samplingRate\":5}}"},
+			ExpectedBody:		"{\n\t\"status\": \"requested item updated\",\n\t\"data\": {\n\t\t\"id\": \"id_test\",\n\t\t\"deviceModel\": \"deviceModel_test\",\n\t\t\"name\": \"name_test\",\n\t\t\"note\": \"note_test\",\n\t\t\"serial\": \"serial_test\",\n\t\t\"attributes\": {\n\t\t\t\"samplingRate\": 5\n\t\t}\n\t}\n}",
+			ExpectedStatusCode:	200,
+		},
+		{
+			Name:				"** Testing attributes against stored model **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test"}, Body: "{\"attributes\":{\"samplingRate\":\"fast\"}}"},
+			ExpectedBody:		types.NewViolationsResponseJson(400, attributesMessage, []schema.Violation{{Pointer: "/attributes/samplingRate", Message: "must be number"}}),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing new model against stored attributes **",
+			Request:			events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.ADMIN_API_KEY}, PathParameters: map[string]string{"id": "id_test"}, Body: "{\"deviceModel\":\"cameraModel\"}"},
+			ExpectedBody:		types.NewViolationsResponseJson(400, attributesMessage, []schema.Violation{
+				{Pointer: "/attributes/resolution", Message: "is required"},
+				{Pointer: "/attributes/samplingRate", Message: "is not allowed"},
+			}),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing attributes of not existing device **",
+			Request:			events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": "id_test_no"}, Body: "{\"attributes\":{\"samplingRate\":5}}"},
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 404,\n\t\t\"message\": \"Desired device with provided id was not founded\"\n\t}\n}",
+			ExpectedStatusCode:	404,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// requests without explicit headers are sent with an operator key
+		if test.Request.Headers == nil {
+			test.Request.Headers = map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}
+		}
+
+		response, _ := handler(context.Background(), test.Request)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("PATCH", "/devices/{id}", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+	}
+} // end of TestUpdateDeviceAttributes function
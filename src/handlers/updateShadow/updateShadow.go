@@ -0,0 +1,320 @@
+package main
+
+import (
+	"api"
+	"apigw"
+	"auth"
+	"config"
+	"devices"
+	"policy"
+	"localserver"
+	"metrics"
+	"retry"
+	"schema"
+	"shadows"
+	"types"
+	"fmt"
+	"time"
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// how many times the shadow is read again when it's changed concurrently
+const MAX_CONFLICT_RETRIES = 3
+
+var ErrDeviceNotFound = errors.New("device not found")
+var ErrDeviceRetired = errors.New("device is retired")
+var ErrVersionConflict = errors.New("shadow is at another version")
+var ErrTooManyConflicts = errors.New("shadow is changed concurrently too many times")
+var ErrTooManyKeys = fmt.Errorf("A shadow document can have at most %d keys", types.MAX_SHADOW_KEYS)
+
+// reasons of rejected inputs, they are Reason dimension of ValidationFailures metric
+const REASON_EMPTY_BODY = "empty_body"
+const REASON_INVALID_JSON = "invalid_json"
+const REASON_SCHEMA_VIOLATION = "schema_violation"
+const REASON_TOO_MANY_KEYS = "too_many_keys"
+
+type SuccessResponse = types.ShadowResponse
+
+// device item as far as the handler reads it, Shadow is only set for devices that still keep it in their item (see shadows.Legacy)
+type storedDevice struct {
+	Status	string			`json:"status"`
+	Shadow	*types.Shadow	`json:"shadow"`
+}
+
+// devices table of the handler and the store of shadows, they are built by newHandler from apigw.Services
+type dynamoDBAPI struct{
+	DynamoDB dynamodbiface.DynamoDBAPI
+	TableName *string
+	Retry *retry.Policy
+	Now func() time.Time
+	Shadows *shadows.Store
+}
+
+// main AWS lambda function starting point.
+// PATCH /devices/{id}/shadow/desired changes desired state of a device (operators push configuration) and
+// PATCH /devices/{id}/shadow/reported changes its reported state (devices report what they applied).
+// Keys of the body's state are set (null removes them) and other keys stay as they are. The shadow is written
+// back only if nobody changed it in between, and only at the sent version when there is one.
+func (ig *dynamoDBAPI) UpdateShadow(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+
+	// only callers with devices:write scope get here (see newHandler)
+	principal := auth.FromContext(ctx)
+
+	id := request.PathParameters["id"]
+	if id == "" {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "No ID Field Provided"),
+			StatusCode: 404,
+		}, nil
+	}
+
+	document := request.PathParameters["document"]
+	if document != types.SHADOW_DESIRED && document != types.SHADOW_REPORTED {
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Unknown shadow document, it must be desired or reported: " + document),
+			StatusCode: 404,
+		}, nil
+	}
+
+	// operators can only change desired state and devices can only report theirs
+	if denied := policy.Check(principal, policy.PERMISSION_DEVICES_SHADOW + ":" + document); denied != nil {
+		return *denied, nil
+	}
+
+	update, reason, err := validateInputs(request)
+	if err != nil {
+		metrics.ValidationFailure(ctx, reason)
+		return events.APIGatewayProxyResponse{
+			Body:	err.Error(),
+			StatusCode: 400,
+		}, nil
+	}
+
+	shadow, err := ig.changeShadow(ctx, principal.TenantID, id, document, update)
+	switch err {
+	case nil:
+		successResponseJson, _ := json.MarshalIndent(&SuccessResponse{Status: document + " state updated", Shadow: shadow}, "", "\t")
+		return events.APIGatewayProxyResponse{
+			Body:	string(successResponseJson),
+			StatusCode: 200,
+		}, nil
+	case ErrTooManyKeys:
+		metrics.ValidationFailure(ctx, REASON_TOO_MANY_KEYS)
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(400, err.Error()),
+			StatusCode: 400,
+		}, nil
+	case ErrDeviceNotFound:
+		devices.LogCrossTenantAccess(ctx, ig.DynamoDB, ig.TableName, principal, id)
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(404, "Desired device with provided id was not founded"),
+			StatusCode: 404,
+		}, nil
+	case ErrDeviceRetired:
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, "Retired devices can't be changed"),
+			StatusCode: 409,
+		}, nil
+	case ErrVersionConflict:
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, fmt.Sprintf("Shadow is at version %d, the update was sent for version %d", shadow.Version, *update.Version)),
+			StatusCode: 409,
+		}, nil
+	case ErrTooManyConflicts:
+		return events.APIGatewayProxyResponse{
+			Body:	createErrorResponseJson(409, "Shadow of the device is changed concurrently, please retry"),
+			StatusCode: 409,
+		}, nil
+	}
+	return events.APIGatewayProxyResponse{}, err
+}
+
+// validateInputs returns the update of the body, or reason and error body of rejecting it.
+// body is validated against the shadow schema (GET /schemas/shadow.json).
+func validateInputs(request events.APIGatewayProxyRequest) (types.ShadowUpdateRequest, string, error) {
+	update := types.ShadowUpdateRequest{}
+	if len(request.Body) == 0 {
+		return update, REASON_EMPTY_BODY, errors.New(createErrorResponseJson(400, "No inputs provided, please provide inputs in json format."))
+	}
+
+	var body interface{}
+	if err := json.Unmarshal([]byte(request.Body), &body); err != nil {
+		return update, REASON_INVALID_JSON, errors.New(createErrorResponseJson(400, "Wrong format: Inputs must be a valid json."))
+	}
+
+	violations := api.ValidateShadowUpdate(body)
+	json.Unmarshal([]byte(request.Body), &update)
+	if len(violations) == 0 && len(update.State) == 0 {
+		violations = append(violations, schema.Violation{Pointer: "/state", Message: "must not be empty"})
+	}
+
+	if len(violations) != 0 {
+		errorMessage := "Shadow update doesn't match its schema " + api.SCHEMAS_PATH + "shadow.json"
+		return types.ShadowUpdateRequest{}, REASON_SCHEMA_VIOLATION, errors.New(types.NewViolationsResponseJson(400, errorMessage, violations))
+	}
+	return update, "", nil
+}
+
+func createErrorResponseJson(errorCode int, errorMessage string) (jsonString string) {
+	return types.NewErrorResponseJson(errorCode, errorMessage)
+}
+
+// changeShadow reads the shadow of a device, applies update to its document and writes it back only if nobody
+// changed it in between, otherwise it starts over. It returns the changed shadow, or the current one with
+// ErrVersionConflict when the update was sent for another version. Shadows of retired devices aren't changed,
+// a device that is retired while its shadow is written still gets that last change.
+func (ig *dynamoDBAPI) changeShadow(ctx context.Context, tenantId string, id string, document string, update types.ShadowUpdateRequest) (types.Shadow, error) {
+	for attempt := 0; attempt < MAX_CONFLICT_RETRIES; attempt++ {
+		device, err := ig.getItemFromDatabase(ctx, tenantId, id)
+		if err != nil {
+			return types.Shadow{}, err
+		}
+		if device.Status == types.STATUS_RETIRED {
+			return types.Shadow{}, ErrDeviceRetired
+		}
+
+		// a shadow that is still kept in the device item is moved to the shadows table by its first change
+		current, found, err := ig.Shadows.Get(ctx, tenantId, id)
+		if err != nil {
+			return types.Shadow{}, err
+		}
+		if !found && device.Shadow != nil {
+			current = *device.Shadow
+		}
+		if update.Version != nil && *update.Version != current.Version {
+			return current.WithDelta(), ErrVersionConflict
+		}
+
+		changed := apply(current.WithDelta(), document, update.State, ig.Now().UTC().Format(time.RFC3339))
+		if len(changed.Desired) > types.MAX_SHADOW_KEYS || len(changed.Reported) > types.MAX_SHADOW_KEYS {
+			return types.Shadow{}, ErrTooManyKeys
+		}
+
+		err = ig.Shadows.Put(ctx, tenantId, id, found, current.Version, changed)
+		if err == shadows.ErrVersionChanged {
+			continue
+		}
+		if err != nil {
+			return types.Shadow{}, err
+		}
+		if device.Shadow != nil {
+			ig.removeLegacyShadow(ctx, tenantId, id)
+		}
+		return changed.WithDelta(), nil
+	}
+	return types.Shadow{}, ErrTooManyConflicts
+}
+
+// apply sets keys of state in document of the shadow (null values remove them) and increases its version,
+// set keys get the new version and at in their metadata
+func apply(shadow types.Shadow, document string, state map[string]interface{}, at string) types.Shadow {
+	shadow.Version++
+	values, metadata := shadow.Desired, shadow.Metadata.Desired
+	if document == types.SHADOW_REPORTED {
+		values, metadata = shadow.Reported, shadow.Metadata.Reported
+	}
+
+	for key, value := range state {
+		if value == nil {
+			delete(values, key)
+			delete(metadata, key)
+			continue
+		}
+		values[key] = value
+		metadata[key] = types.ShadowKeyMetadata{Version: shadow.Version, UpdatedAt: at}
+	}
+	return shadow
+}
+
+// function that returns status and the shadow that a device of the tenant still keeps in its item, ErrDeviceNotFound when it doesn't exist
+func (ig *dynamoDBAPI) getItemFromDatabase(ctx context.Context, tenantId string, id string) (storedDevice, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: ig.TableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		ProjectionExpression: aws.String("id, #status, #shadow"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status"), "#shadow": aws.String(shadows.LEGACY_ATTRIBUTE)},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	var output *dynamodb.GetItemOutput
+	err := ig.Retry.Do(ctx, func() (err error) {
+		output, err = ig.DynamoDB.GetItemWithContext(ctx, input)
+		return err
+	})
+	if err != nil {
+		return storedDevice{}, err
+	}
+	if len(output.Item) == 0 {
+		return storedDevice{}, ErrDeviceNotFound
+	}
+
+	device := storedDevice{}
+	err = dynamodbattribute.UnmarshalMap(output.Item, &device)
+	return device, err
+}
+
+// function that removes the shadow that the device item still keeps after it's moved to the shadows table.
+// The moved shadow is read from then on, so a failure is only logged and the attribute is removed by a later change.
+func (ig *dynamoDBAPI) removeLegacyShadow(ctx context.Context, tenantId string, id string) {
+	input := &dynamodb.UpdateItemInput{
+		TableName: ig.TableName,
+		Key: map[string]*dynamodb.AttributeValue{
+			"tenantId": {
+				S: aws.String(tenantId),
+			},
+			"id": {
+				S: aws.String(id),
+			},
+		},
+		UpdateExpression: aws.String("REMOVE #shadow"),
+		ConditionExpression: aws.String("attribute_exists(id)"),
+		ExpressionAttributeNames: map[string]*string{"#shadow": aws.String(shadows.LEGACY_ATTRIBUTE)},
+	}
+
+	err := ig.Retry.Do(ctx, func() error {
+		_, err := ig.DynamoDB.UpdateItemWithContext(ctx, input)
+		return err
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return
+	}
+	if err != nil {
+		fmt.Println("There is an error while removing the moved shadow of device " + id + ": " + err.Error())
+	}
+}
+
+// newHandler wraps UpdateShadow with the shared middlewares (logging, recovery, database check, authentication, rate limiting),
+// all of its dependencies come from services. The shadows table is only needed by shadow handlers, so it's checked here.
+func newHandler(services *apigw.Services) apigw.Handler {
+	if len(services.Config.ShadowsTableName) == 0 && services.ConfigError == nil {
+		services.ConfigError = &config.Error{Problems: []string{"SHADOWS_TABLE_NAME is not set"}}
+	}
+	store := &shadows.Store{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.ShadowsTableName), Retry: services.Retry}
+	table := &dynamoDBAPI{DynamoDB: services.DynamoDB, TableName: aws.String(services.Config.DevicesTableName), Retry: services.Retry, Now: time.Now, Shadows: store}
+	return apigw.Chain(table.UpdateShadow, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+}
+
+func main(){
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Println("Handler is started with " + err.Error())
+	}
+	services := apigw.NewServices(cfg, err)
+	localserver.Start(cfg, services.MetricsHandler, newHandler(services), api.LocalRoutes("updateShadow")...)
+}
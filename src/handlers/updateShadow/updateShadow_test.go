@@ -0,0 +1,273 @@
+package main
+
+import(
+	"api"
+	"apigw"
+	"apigwtest"
+	"auth"
+	"retry"
+	"shadows"
+	"types"
+	"testing"
+	"context"
+	"time"
+	"errors"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+// TestCase struct that contains all reuested and expected values for unit testing
+type TestCase struct {
+	Name 						string
+	InputRequest 				events.APIGatewayProxyRequest
+	ExpectedBody 				string
+	ExpectedStatusCode 			int
+	ExpectedShadow 				string // data of a successful response as compact json
+}
+
+// A fakeDynamoDB instance for mocking test that emulates real DynamoDB with devices of "tenant_test":
+// "id_test" without a shadow, "id_shadow" with a shadow at version 2 in the shadows table, "id_legacy" with the same
+// shadow still in its device item and "id_retired". Shadow of "id_busy" is always changed by somebody else before it's written.
+type FakeDynamoDBAPI struct {
+	dynamodbiface.DynamoDBAPI
+	items	map[string]map[string]*dynamodb.AttributeValue
+	shadows	map[string]map[string]*dynamodb.AttributeValue
+	Updates	int
+}
+
+// a mocked version of DynamoDB's Query function on the id index, it's used for logging cross-tenant access
+// attempts and devices of other tenants aren't known
+func (fd *FakeDynamoDBAPI) QueryWithContext(ctx aws.Context, input *dynamodb.QueryInput, opts ...request.Option) (*dynamodb.QueryOutput, error) {
+	return &dynamodb.QueryOutput{}, nil
+}
+
+func newFakeDynamoDBAPI() *FakeDynamoDBAPI {
+	shadow, _ := dynamodbattribute.MarshalMap(map[string]interface{}{
+		"desired":	map[string]interface{}{"samplingRate": 5, "mode": "eco"},
+		"reported":	map[string]interface{}{"samplingRate": 1, "mode": "eco"},
+		"metadata":	types.ShadowMetadata{
+			Desired:	map[string]types.ShadowKeyMetadata{"samplingRate": {Version: 2, UpdatedAt: "2018-06-26T07:00:00Z"}, "mode": {Version: 1, UpdatedAt: "2018-06-26T06:00:00Z"}},
+			Reported:	map[string]types.ShadowKeyMetadata{"samplingRate": {Version: 1, UpdatedAt: "2018-06-26T06:00:00Z"}, "mode": {Version: 1, UpdatedAt: "2018-06-26T06:00:00Z"}},
+		},
+		"version":	2,
+	})
+	fd := &FakeDynamoDBAPI{items: map[string]map[string]*dynamodb.AttributeValue{}, shadows: map[string]map[string]*dynamodb.AttributeValue{}}
+	for _, id := range []string{"id_test", "id_shadow", "id_legacy", "id_retired", "id_busy"} {
+		fd.items[id] = map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}}
+	}
+	fd.shadows["tenant_test#id_shadow"] = shadow
+	fd.items["id_legacy"]["shadow"] = &dynamodb.AttributeValue{M: shadow}
+	fd.items["id_retired"]["status"] = &dynamodb.AttributeValue{S: aws.String(types.STATUS_RETIRED)}
+	return fd
+}
+
+// a mocked version of DynamoDB's GetItem function
+func (fd *FakeDynamoDBAPI) GetItemWithContext(ctx aws.Context, input *dynamodb.GetItemInput, opts ...request.Option) (*dynamodb.GetItemOutput, error) {
+	output := new(dynamodb.GetItemOutput)
+	if *input.TableName == "test_shadows_table_name" {
+		output.SetItem(fd.shadows[*input.Key["device"].S])
+		return output, nil
+	}
+
+	id := *input.Key["id"].S
+	if id == "id_error" {
+		return nil, errors.New("Unexpected Error has occured")
+	}
+	if *input.Key["tenantId"].S == "tenant_test" {
+		output.SetItem(fd.items[id])
+	}
+	return output, nil
+}
+
+// a mocked version of DynamoDB's PutItem function on the shadows table, it checks the version of the condition against the stored shadow
+func (fd *FakeDynamoDBAPI) PutItemWithContext(ctx aws.Context, input *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	fd.Updates++
+	device := *input.Item["device"].S
+	stored := fd.shadows[device]
+	var expected *dynamodb.AttributeValue
+	if input.ExpressionAttributeValues != nil {
+		expected = input.ExpressionAttributeValues[":version"]
+	}
+	if device == "tenant_test#id_busy" || (stored == nil) != (expected == nil) || (stored != nil && *stored["version"].N != *expected.N) {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+	fd.shadows[device] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+// a mocked version of DynamoDB's UpdateItem function, it's only used for removing shadows from device items
+func (fd *FakeDynamoDBAPI) UpdateItemWithContext(ctx aws.Context, input *dynamodb.UpdateItemInput, opts ...request.Option) (*dynamodb.UpdateItemOutput, error) {
+	delete(fd.items[*input.Key["id"].S], *input.ExpressionAttributeNames["#shadow"])
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func TestUpdateShadow(t *testing.T) {
+
+	update := func(id string, document string, body string) events.APIGatewayProxyRequest {
+		return events.APIGatewayProxyRequest{PathParameters: map[string]string{"id": id, "document": document}, Body: body}
+	}
+	reported := func(id string, body string) events.APIGatewayProxyRequest {
+		request := update(id, "reported", body)
+		request.Headers = map[string]string{"X-Api-Key": apigwtest.DEVICE_API_KEY}
+		return request
+	}
+	errorBody := func(code int, message string) string {
+		return types.NewErrorResponseJson(code, message)
+	}
+
+	testCases := []TestCase{
+		{
+			Name:				"** Testing first desired state of a device **",
+			InputRequest:		update("id_test", "desired", "{\"state\": {\"samplingRate\": 10, \"led\": {\"color\": \"red\"}}}"),
+			ExpectedStatusCode:	200,
+			ExpectedShadow:		"{\"desired\":{\"led\":{\"color\":\"red\"},\"samplingRate\":10},\"reported\":{},\"delta\":{\"led\":{\"color\":\"red\"},\"samplingRate\":10}," +
+				"\"metadata\":{\"desired\":{\"led\":{\"version\":1,\"updatedAt\":\"2018-06-26T08:00:00Z\"},\"samplingRate\":{\"version\":1,\"updatedAt\":\"2018-06-26T08:00:00Z\"}},\"reported\":{}},\"version\":1}",
+		},
+		{
+			Name:				"** Testing device reports desired state, delta shrinks **",
+			InputRequest:		reported("id_shadow", "{\"state\": {\"samplingRate\": 5}, \"version\": 2}"),
+			ExpectedStatusCode:	200,
+			ExpectedShadow:		"{\"desired\":{\"mode\":\"eco\",\"samplingRate\":5},\"reported\":{\"mode\":\"eco\",\"samplingRate\":5},\"delta\":{}," +
+				"\"metadata\":{\"desired\":{\"mode\":{\"version\":1,\"updatedAt\":\"2018-06-26T06:00:00Z\"},\"samplingRate\":{\"version\":2,\"updatedAt\":\"2018-06-26T07:00:00Z\"}}," +
+				"\"reported\":{\"mode\":{\"version\":1,\"updatedAt\":\"2018-06-26T06:00:00Z\"},\"samplingRate\":{\"version\":3,\"updatedAt\":\"2018-06-26T08:00:00Z\"}}},\"version\":3}",
+		},
+		{
+			Name:				"** Testing shadow of the device item is moved to the shadows table **",
+			InputRequest:		reported("id_legacy", "{\"state\": {\"samplingRate\": 5}, \"version\": 2}"),
+			ExpectedStatusCode:	200,
+			ExpectedShadow:		"{\"desired\":{\"mode\":\"eco\",\"samplingRate\":5},\"reported\":{\"mode\":\"eco\",\"samplingRate\":5},\"delta\":{}," +
+				"\"metadata\":{\"desired\":{\"mode\":{\"version\":1,\"updatedAt\":\"2018-06-26T06:00:00Z\"},\"samplingRate\":{\"version\":2,\"updatedAt\":\"2018-06-26T07:00:00Z\"}}," +
+				"\"reported\":{\"mode\":{\"version\":1,\"updatedAt\":\"2018-06-26T06:00:00Z\"},\"samplingRate\":{\"version\":3,\"updatedAt\":\"2018-06-26T08:00:00Z\"}}},\"version\":3}",
+		},
+		{
+			Name:				"** Testing null removes a key **",
+			InputRequest:		update("id_shadow", "desired", "{\"state\": {\"mode\": null}}"),
+			ExpectedStatusCode:	200,
+			ExpectedShadow:		"{\"desired\":{\"samplingRate\":5},\"reported\":{\"mode\":\"eco\",\"samplingRate\":1},\"delta\":{\"samplingRate\":5}," +
+				"\"metadata\":{\"desired\":{\"samplingRate\":{\"version\":2,\"updatedAt\":\"2018-06-26T07:00:00Z\"}}," +
+				"\"reported\":{\"mode\":{\"version\":1,\"updatedAt\":\"2018-06-26T06:00:00Z\"},\"samplingRate\":{\"version\":1,\"updatedAt\":\"2018-06-26T06:00:00Z\"}}},\"version\":3}",
+		},
+		{
+			Name:				"** Testing update of an old version **",
+			InputRequest:		update("id_shadow", "desired", "{\"state\": {\"mode\": \"turbo\"}, \"version\": 1}"),
+			ExpectedBody:		errorBody(409, "Shadow is at version 2, the update was sent for version 1"),
+			ExpectedStatusCode:	409,
+		},
+		{
+			Name:				"** Testing shadow that keeps changing **",
+			InputRequest:		update("id_busy", "desired", "{\"state\": {\"mode\": \"turbo\"}}"),
+			ExpectedBody:		errorBody(409, "Shadow of the device is changed concurrently, please retry"),
+			ExpectedStatusCode:	409,
+		},
+		{
+			Name:				"** Testing operator can't report state **",
+			InputRequest:		update("id_shadow", "reported", "{\"state\": {\"mode\": \"turbo\"}}"),
+			ExpectedBody:		errorBody(403, "Operation is not permitted: none of roles [operator] grants devices:shadow:reported"),
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name:				"** Testing device can't change desired state **",
+			InputRequest:		events.APIGatewayProxyRequest{Headers: map[string]string{"X-Api-Key": apigwtest.DEVICE_API_KEY}, PathParameters: map[string]string{"id": "id_shadow", "document": "desired"}},
+			ExpectedBody:		errorBody(403, "Operation is not permitted: none of roles [device] grants devices:shadow:desired"),
+			ExpectedStatusCode:	403,
+		},
+		{
+			Name:				"** Testing unknown document **",
+			InputRequest:		update("id_shadow", "actual", "{\"state\": {\"mode\": \"turbo\"}}"),
+			ExpectedBody:		errorBody(404, "Unknown shadow document, it must be desired or reported: actual"),
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing schema violations **",
+			InputRequest:		update("id_shadow", "desired", "{\"state\": {\"sampling rate\": 5}, \"reported\": {}}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Shadow update doesn't match its schema /schemas/shadow.json\",\n\t\t\"violations\": [\n\t\t\t{\n\t\t\t\t\"pointer\": \"/reported\",\n\t\t\t\t\"message\": \"is not allowed\"\n\t\t\t},\n\t\t\t{\n\t\t\t\t\"pointer\": \"/state/sampling rate\",\n\t\t\t\t\"message\": \"name must match pattern ^[A-Za-z0-9_.-]+$\"\n\t\t\t}\n\t\t]\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing null state **",
+			InputRequest:		update("id_shadow", "desired", "{\"state\": null}"),
+			ExpectedBody:		"{\n\t\"error\": {\n\t\t\"code\": 400,\n\t\t\"message\": \"Shadow update doesn't match its schema /schemas/shadow.json\",\n\t\t\"violations\": [\n\t\t\t{\n\t\t\t\t\"pointer\": \"/state\",\n\t\t\t\t\"message\": \"must be object\"\n\t\t\t}\n\t\t]\n\t}\n}",
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing empty body **",
+			InputRequest:		update("id_shadow", "desired", ""),
+			ExpectedBody:		errorBody(400, "No inputs provided, please provide inputs in json format."),
+			ExpectedStatusCode:	400,
+		},
+		{
+			Name:				"** Testing retired device **",
+			InputRequest:		update("id_retired", "desired", "{\"state\": {\"mode\": \"turbo\"}}"),
+			ExpectedBody:		errorBody(409, "Retired devices can't be changed"),
+			ExpectedStatusCode:	409,
+		},
+		{
+			Name:				"** Testing missing device **",
+			InputRequest:		update("id_missing", "desired", "{\"state\": {\"mode\": \"turbo\"}}"),
+			ExpectedBody:		errorBody(404, "Desired device with provided id was not founded"),
+			ExpectedStatusCode:	404,
+		},
+		{
+			Name:				"** Testing database internal problem **",
+			InputRequest:		update("id_error", "desired", "{\"state\": {\"mode\": \"turbo\"}}"),
+			ExpectedBody:		errorBody(500, "Internal Server's Error occured"),
+			ExpectedStatusCode:	500,
+		},
+	}
+
+	for _, test := range testCases {
+
+		// create mocked databases, shadows are changed at a fixed time
+		devices := newFakeDynamoDBAPI()
+		services := apigwtest.NewServices(devices, nil)
+		store := &shadows.Store{DynamoDB: devices, TableName: aws.String("test_shadows_table_name"), Retry: retry.Default}
+		updates := &dynamoDBAPI{DynamoDB: devices, TableName: aws.String("test_table_name"), Retry: retry.Default, Now: func() time.Time { return time.Unix(1530000000, 0) }, Shadows: store}
+		handler := apigw.Chain(updates.UpdateShadow, apigw.Standard(services, auth.SCOPE_DEVICES_WRITE)...)
+
+		// requests without explicit headers are sent with an operator key
+		if test.InputRequest.Headers == nil {
+			test.InputRequest.Headers = map[string]string{"X-Api-Key": apigwtest.OPERATOR_API_KEY}
+		}
+
+		// calls updateShadow.go's UpdateShadow function.
+		response, _ := handler(context.Background(), test.InputRequest)
+
+		// responses must match the OpenAPI document
+		for _, problem := range api.CheckResponse("PATCH", "/devices/{id}/shadow/{document}", response) {
+			t.Errorf("%s \n \t<response drifted from the document: %s>", test.Name, problem)
+		}
+
+		if len(test.ExpectedShadow) != 0 {
+			result := types.ShadowResponse{}
+			json.Unmarshal([]byte(response.Body), &result)
+			shadow, _ := json.Marshal(result.Shadow)
+			if response.StatusCode != test.ExpectedStatusCode || string(shadow) != test.ExpectedShadow {
+				t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected shadow: %s> \n \t<resulted shadow: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedShadow, shadow)
+			}
+
+			// the stored shadow is the one of the response and device items don't keep shadows anymore
+			id := test.InputRequest.PathParameters["id"]
+			stored := types.Shadow{}
+			dynamodbattribute.UnmarshalMap(devices.shadows["tenant_test#" + id], &stored)
+			if storedShadow, _ := json.Marshal(stored.WithDelta()); string(storedShadow) != test.ExpectedShadow || devices.items[id]["shadow"] != nil {
+				t.Errorf("%s \n \t<expected stored shadow: %s> \n \t<resulted stored shadow: %s> <resulted device item: %v>", test.Name, test.ExpectedShadow, storedShadow, devices.items[id])
+			}
+			continue
+		}
+
+		if response.StatusCode != test.ExpectedStatusCode ||  response.Body != test.ExpectedBody{
+			t.Errorf("%s \n \t<expected error-code: %d> <resulted error-code: %d> \n \t<expected body: %s> <resulted body: %s>", test.Name, test.ExpectedStatusCode, response.StatusCode, test.ExpectedBody, response.Body)
+		}
+
+		if test.ExpectedStatusCode == 409 && devices.Updates > MAX_CONFLICT_RETRIES {
+			t.Errorf("%s \n \t<expected updates: at most %d> <resulted updates: %d>", test.Name, MAX_CONFLICT_RETRIES, devices.Updates)
+		}
+	}
+
+} // end of TestUpdateShadow function